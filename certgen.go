@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/imds"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+	"github.com/Azure/azure-docker-extension/pkg/tlsutil"
+)
+
+const (
+	generatedCADir  = "ca" // in statefile.Dir
+	generatedCACert = "ca.pem"
+	generatedCAKey  = "ca-key.pem"
+
+	clientCertDir = "docker-tls" // in home directory of the admin user
+
+	generatedCAValidity   = 10 * 365 * 24 * time.Hour
+	generatedCertValidity = 365 * 24 * time.Hour
+)
+
+// generateDockerCerts creates a CA (kept in the state directory of the
+// extension), a server certificate for the host names and IP addresses of the
+// VM installed to dstDir, and a client certificate saved to ~/docker-tls of
+// the given user. Existing certificates are kept unless they are about to
+// expire or (for the server certificate) do not cover the current host names
+// and IP addresses of the VM.
+func generateDockerCerts(dstDir, userName string) error {
+	now := time.Now()
+	ca, caChanged, err := loadOrGenerateCA(now)
+	if err != nil {
+		return err
+	}
+
+	hosts := append(tlsutil.HostNames(), "localhost", "127.0.0.1")
+	if n, err := imds.GetNetwork(); err != nil {
+		addWarning("cannot get public IP addresses of the VM, server certificate is generated without them: %v", err)
+	} else {
+		hosts = append(hosts, n.PublicIPs()...)
+	}
+
+	srvFiles := [3]string{
+		filepath.Join(dstDir, dockerCaCert),
+		filepath.Join(dstDir, dockerSrvCert),
+		filepath.Join(dstDir, dockerSrvKey),
+	}
+	if caChanged || !certCovers(srvFiles, ca, x509.ExtKeyUsageServerAuth, hosts, now) {
+		log.Printf("Generating docker server certificate for %v", hosts)
+		srv, err := tlsutil.GenerateServerCert(ca, hosts[0], hosts, generatedCertValidity)
+		if err != nil {
+			return fmt.Errorf("error generating server certificate: %v", err)
+		}
+		if err := writeCertFiles(srvFiles, ca.CertPEM, srv, -1, -1); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("docker server certificate is up to date")
+	}
+
+	home, uid, gid, err := lookupUser(userName)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(home, clientCertDir)
+	clientFiles := [3]string{
+		filepath.Join(dir, dockerCaCert),
+		filepath.Join(dir, dockerSrvCert),
+		filepath.Join(dir, dockerSrvKey),
+	}
+	if caChanged || !certCovers(clientFiles, ca, x509.ExtKeyUsageClientAuth, nil, now) {
+		log.Printf("Generating docker client certificate to %s", dir)
+		client, err := tlsutil.GenerateClientCert(ca, userName, generatedCertValidity)
+		if err != nil {
+			return fmt.Errorf("error generating client certificate: %v", err)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("error creating %s: %v", dir, err)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("error changing owner of %s: %v", dir, err)
+		}
+		if err := writeCertFiles(clientFiles, ca.CertPEM, client, uid, gid); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("docker client certificate is up to date")
+	}
+	return nil
+}
+
+// loadOrGenerateCA loads the CA generated previously, or generates a new one
+// if it does not exist or is about to expire.
+func loadOrGenerateCA(now time.Time) (ca *tlsutil.KeyPair, generated bool, err error) {
+	dir := filepath.Join(statefile.Dir, generatedCADir)
+	certPath, keyPath := filepath.Join(dir, generatedCACert), filepath.Join(dir, generatedCAKey)
+
+	cert, cerr := ioutil.ReadFile(certPath)
+	key, kerr := ioutil.ReadFile(keyPath)
+	if cerr == nil && kerr == nil {
+		ca, err := tlsutil.LoadKeyPair(cert, key)
+		if err == nil && ca.Cert.NotAfter.Sub(now) > tlsutil.ExpiryWarningPeriod {
+			return ca, false, nil
+		}
+		log.Printf("generated CA at %s is invalid or about to expire (%v), regenerating", dir, err)
+	}
+
+	log.Printf("Generating CA for docker certificates")
+	if ca, err = tlsutil.GenerateCA("Docker Extension CA", generatedCAValidity); err != nil {
+		return nil, false, fmt.Errorf("error generating CA: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, false, fmt.Errorf("error creating %s: %v", dir, err)
+	}
+	if err := ioutil.WriteFile(keyPath, ca.KeyPEM, 0600); err != nil {
+		return nil, false, fmt.Errorf("error writing %s: %v", keyPath, err)
+	}
+	if err := ioutil.WriteFile(certPath, ca.CertPEM, 0644); err != nil {
+		return nil, false, fmt.Errorf("error writing %s: %v", certPath, err)
+	}
+	return ca, true, nil
+}
+
+// certCovers reports whether the CA, certificate and key files exist and the
+// certificate is usable for given hosts without renewal.
+func certCovers(files [3]string, ca *tlsutil.KeyPair, usage x509.ExtKeyUsage, hosts []string, now time.Time) bool {
+	caPEM, err := ioutil.ReadFile(files[0])
+	if err != nil || string(caPEM) != string(ca.CertPEM) {
+		return false
+	}
+	cert, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		return false
+	}
+	key, err := ioutil.ReadFile(files[2])
+	if err != nil {
+		return false
+	}
+	kp, err := tlsutil.LoadKeyPair(cert, key)
+	if err != nil {
+		return false
+	}
+	return kp.Covers(ca, usage, hosts, now)
+}
+
+// writeCertFiles saves the CA certificate, certificate and key to the given
+// paths, changing their owner if uid and gid are not -1.
+func writeCertFiles(files [3]string, caPEM []byte, kp *tlsutil.KeyPair, uid, gid int) error {
+	for i, b := range [][]byte{caPEM, kp.CertPEM, kp.KeyPEM} {
+		if err := writeUserFile(files[i], b, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
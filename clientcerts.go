@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/tlsutil"
+)
+
+const dockerCLIConfigDir = ".docker" // in home directory of the admin user
+
+// installClientCerts saves the client certificates in the settings to
+// ~/.docker of the given user, where the docker CLI looks for them with
+// --tlsverify, and creates the configured docker CLI context using them.
+// If no client certificates are provided, nothing is written.
+func installClientCerts(s DockerHandlerSettings, userName string) error {
+	if !s.ClientCerts.HasDockerCerts() {
+		if s.ClientContext.Name != "" {
+			return fmt.Errorf("client-context requires client-certs in the protected settings")
+		}
+		log.Printf("client certs are not provided in the extension settings, skipping")
+		return nil
+	}
+
+	ca := decodeSetting(s.ClientCerts.CABase64)
+	cert := decodeSetting(s.ClientCerts.ServerCertBase64)
+	key := decodeSetting(s.ClientCerts.ServerKeyBase64)
+	w, err := tlsutil.ValidateClient(ca, cert, key, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, msg := range w {
+		addWarning("client certs: %s", msg)
+	}
+
+	home, uid, gid, err := lookupUser(userName)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(home, dockerCLIConfigDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating %s: %v", dir, err)
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return fmt.Errorf("error changing owner of %s: %v", dir, err)
+	}
+	files := [3]string{
+		filepath.Join(dir, dockerCaCert),
+		filepath.Join(dir, dockerSrvCert),
+		filepath.Join(dir, dockerSrvKey),
+	}
+	for i, b := range [][]byte{ca, cert, key} {
+		if err := writeUserFile(files[i], b, uid, gid); err != nil {
+			return err
+		}
+	}
+	log.Printf("Installed client certs to %s", dir)
+
+	if s.ClientContext.Name == "" {
+		return nil
+	}
+	return createDockerContext(dir, s.ClientContext, files, uid, gid)
+}
+
+// createDockerContext (re)creates the docker CLI context in the given docker
+// CLI configuration directory, using the given TLS files.
+func createDockerContext(configDir string, c clientContextSettings, files [3]string, uid, gid int) error {
+	if c.Host == "" {
+		return fmt.Errorf("client-context %s requires a host", c.Name)
+	}
+	if _, err := executil.Exec("docker", "--config", configDir, "context", "inspect", c.Name); err == nil {
+		if _, err := executil.Exec("docker", "--config", configDir, "context", "rm", "-f", c.Name); err != nil {
+			return fmt.Errorf("error removing existing docker context %s: %v", c.Name, err)
+		}
+	}
+	ep := fmt.Sprintf("host=%s,ca=%s,cert=%s,key=%s", c.Host, files[0], files[1], files[2])
+	log.Printf("Creating docker context %s for %s", c.Name, c.Host)
+	if out, err := executil.Exec("docker", "--config", configDir, "context", "create", c.Name, "--docker", ep); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("error creating docker context %s: %v", c.Name, err)
+	}
+	if c.Default {
+		if _, err := executil.Exec("docker", "--config", configDir, "context", "use", c.Name); err != nil {
+			return fmt.Errorf("error using docker context %s: %v", c.Name, err)
+		}
+	}
+	// the CLI creates the context metadata as root
+	return filepath.Walk(configDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// lookupUser returns the home directory, uid and gid of the given user.
+func lookupUser(name string) (home string, uid, gid int, err error) {
+	if name == "" {
+		return "", 0, 0, fmt.Errorf("the provisioned admin user of the VM is unknown")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("cannot find user %s: %v", name, err)
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid uid of user %s: %v", name, err)
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid gid of user %s: %v", name, err)
+	}
+	return u.HomeDir, uid, gid, nil
+}
+
+// writeUserFile saves the file with 0600 permissions and owned by the given
+// uid and gid (-1 keeps the owner unchanged).
+func writeUserFile(path string, b []byte, uid, gid int) error {
+	if err := backup.Save(path); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	// WriteFile does not change the mode of existing files
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("error changing mode of %s: %v", path, err)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("error changing owner of %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	dockerDaemonConfig = "daemon.json"
+
+	// daemonConfigState is the name of the state file that keeps the list
+	// of daemon.json keys managed by the extension.
+	daemonConfigState = "daemon-config"
+)
+
+// updateDaemonConfig merges the configuration managed by the extension into the
+// daemon.json at path, tracking the managed keys in the given state file. Keys
+// not managed by the extension are preserved, keys managed previously but no
+// longer configured are removed. Returns error if the resulting configuration
+// conflicts with the specified command line flags of the daemon. The defaults
+// are applied (and managed afterwards) only if none of their keys are
+// configured otherwise. If the effective configuration is not changed, the
+// file is not written and this returns false.
+func updateDaemonConfig(path, state string, managed, defaults dockeropts.DaemonConfig, flags []string) (bool, error) {
+	existing, prevManaged, fileExists, err := readDaemonConfig(path, state)
+	if err != nil {
+		return false, err
+	}
+
+	cfg := dockeropts.MergeDaemonConfig(existing, managed, prevManaged)
+	if !hasAnyKey(cfg, defaults.Keys()) && !hasAnyFlag(flags, defaults) {
+		log.Printf("Applying default daemon config keys: %v", defaults.Keys())
+		managed = dockeropts.MergeDaemonConfig(managed, defaults, nil)
+		cfg = dockeropts.MergeDaemonConfig(cfg, defaults, nil)
+	}
+	if c := dockeropts.FlagConflicts(flags, cfg); len(c) > 0 {
+		return false, fmt.Errorf("daemon options [%s] are specified both as command line flags and in %s", strings.Join(c, ", "), path)
+	}
+	changed := !cfg.Equal(existing) || (!fileExists && len(cfg) > 0)
+	if changed {
+		if err := writeDaemonConfig(path, cfg); err != nil {
+			return false, err
+		}
+		log.Printf("Updated %s, managed keys: %v", path, managed.Keys())
+	} else {
+		log.Printf("%s is up to date", path)
+	}
+
+	// the state is saved only once daemon.json is written, so that it never
+	// claims keys not written by the extension. It is backed up so that it is
+	// rolled back (or restored) along with the daemon.json.
+	if err := backup.Save(statefile.Path(state)); err != nil {
+		return false, err
+	}
+	if err := statefile.Set(state, managed.Keys()); err != nil {
+		return false, fmt.Errorf("error saving managed daemon config keys: %v", err)
+	}
+	return changed, nil
+}
+
+func writeDaemonConfig(path string, cfg dockeropts.DaemonConfig) error {
+	out, err := cfg.Marshal()
+	if err != nil {
+		return fmt.Errorf("error serializing daemon config: %v", err)
+	}
+	if err := backup.Save(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// readDaemonConfig returns the daemon.json at path (empty if it does not exist)
+// and the keys managed previously according to the given state file.
+func readDaemonConfig(path, state string) (cfg dockeropts.DaemonConfig, prevManaged []string, exists bool, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, false, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	exists = err == nil
+	if cfg, err = dockeropts.ParseDaemonConfig(b); err != nil {
+		return nil, nil, false, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if _, err := statefile.Get(state, &prevManaged); err != nil {
+		return nil, nil, false, fmt.Errorf("error reading managed daemon config keys: %v", err)
+	}
+	return cfg, prevManaged, exists, nil
+}
+
+// effectiveDaemonConfig returns the configuration the daemon runs with once
+// the managed configuration is merged into the daemon.json at path and the
+// daemon is started with the given command line flags.
+func effectiveDaemonConfig(path, state string, managed dockeropts.DaemonConfig, flags []string) (dockeropts.DaemonConfig, error) {
+	existing, prevManaged, _, err := readDaemonConfig(path, state)
+	if err != nil {
+		return nil, err
+	}
+	flagCfg, _, err := dockeropts.ParseFlags(flags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid daemon options: %v", err)
+	}
+	return dockeropts.MergeDaemonConfig(dockeropts.MergeDaemonConfig(existing, managed, prevManaged), flagCfg, nil), nil
+}
+
+func hasAnyKey(cfg dockeropts.DaemonConfig, keys []string) bool {
+	for _, k := range keys {
+		if _, ok := cfg[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyFlag reports whether any of the keys of cfg is specified as a command
+// line flag.
+func hasAnyFlag(flags []string, cfg dockeropts.DaemonConfig) bool {
+	return len(dockeropts.FlagConflicts(flags, cfg)) > 0
+}
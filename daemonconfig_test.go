@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_updateDaemonConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = filepath.Join(dir, "state")
+
+	path := filepath.Join(dir, "daemon.json")
+	if err := ioutil.WriteFile(path, []byte(`{"debug": true, "mtu": 1400}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	managedKeys := func() []string {
+		var l []string
+		if _, err := statefile.Get(daemonConfigState, &l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	if changed, err := updateDaemonConfig(path, daemonConfigState, dockeropts.DaemonConfig{"debug": false}, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Fatal("expected daemon.json to be changed")
+	}
+	if l := managedKeys(); !reflect.DeepEqual(l, []string{"debug"}) {
+		t.Fatalf("got managed keys %v", l)
+	}
+
+	// the state is not updated if daemon.json cannot be written, so that the
+	// unmanaged mtu is kept upon the next update
+	if _, err := updateDaemonConfig(path, daemonConfigState, dockeropts.DaemonConfig{"mtu": math.Inf(1)}, nil, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if l := managedKeys(); !reflect.DeepEqual(l, []string{"debug"}) {
+		t.Fatalf("got managed keys %v after failed write", l)
+	}
+	if changed, err := updateDaemonConfig(path, daemonConfigState, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Fatal("expected daemon.json to be changed")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := dockeropts.ParseDaemonConfig(b); err != nil {
+		t.Fatal(err)
+	} else if !cfg.Equal(dockeropts.DaemonConfig{"mtu": 1400}) {
+		t.Fatalf("got %v, expected unmanaged keys to be kept", cfg)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/driver"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	defaultDataRoot = "/var/lib/docker"
+
+	// dataRootState keeps the data-root setting the engine is started with,
+	// so that the data is only relocated when the setting changes.
+	dataRootState = "data-root"
+
+	defaultResourceDiskMount = "/mnt/resource"
+)
+
+var (
+	waagentConfig = "/etc/waagent.conf"
+	procMounts    = "/proc/mounts"
+)
+
+// dataRootMigration describes the relocation of the docker data-root after a
+// change of the data-root setting.
+type dataRootMigration struct {
+	setting  string // data-root setting, saved once the engine starts with it
+	from, to string // empty if the data-root is not relocated
+}
+
+// dataRootAction is how the data is handled when the data-root is relocated.
+// The data at the old location is never removed.
+type dataRootAction int
+
+const (
+	// dataRootCopy copies the data to the new data-root.
+	dataRootCopy dataRootAction = iota
+	// dataRootFresh starts the engine with an empty data-root.
+	dataRootFresh
+	// dataRootReuse starts the engine with the data already at the new
+	// data-root.
+	dataRootReuse
+)
+
+// validateDataRoot returns error if the given data-root is not a clean
+// absolute path.
+func validateDataRoot(p string) error {
+	if !filepath.IsAbs(p) || filepath.Clean(p) != p || p == "/" {
+		return fmt.Errorf("invalid data-root %q: must be a clean absolute path", p)
+	}
+	return nil
+}
+
+// currentDataRoot returns the data-root of the docker engine, as reported by
+// the running engine or as configured in daemon.json.
+func currentDataRoot(daemonConfigPath string) string {
+	if out, err := executil.Exec("docker", "info", "--format", "{{.DockerRootDir}}"); err == nil {
+		if p := strings.TrimSpace(string(out)); p != "" {
+			return p
+		}
+	}
+	if b, err := ioutil.ReadFile(daemonConfigPath); err == nil {
+		if cfg, err := dockeropts.ParseDaemonConfig(b); err == nil {
+			for _, k := range []string{"data-root", "graph"} {
+				if p, ok := cfg[k].(string); ok && p != "" {
+					return p
+				}
+			}
+		}
+	}
+	return defaultDataRoot
+}
+
+// effectiveDataRoot returns the data-root in the effective daemon
+// configuration.
+func effectiveDataRoot(cfg dockeropts.DaemonConfig) string {
+	for _, k := range []string{"data-root", "graph"} {
+		if p, ok := cfg[k].(string); ok && p != "" {
+			return filepath.Clean(p)
+		}
+	}
+	return defaultDataRoot
+}
+
+// migrateDataRoot relocates the data-root if the data-root setting is changed
+// since the engine was last started, to the given target which is the
+// data-root the engine is configured with (the default or the one set in
+// daemon.json or the daemon options, if the setting is removed). The engine
+// is stopped and the existing data is copied to the target, unless it is on
+// the ephemeral resource disk of the VM (where the engine starts fresh) or it
+// already contains data. The data at the old location is left intact. Returns
+// nil if the setting is not changed.
+func migrateDataRoot(d driver.DistroDriver, daemonConfigPath, setting, target string) (*dataRootMigration, error) {
+	var prev string
+	if _, err := statefile.Get(dataRootState, &prev); err != nil {
+		return nil, fmt.Errorf("error reading data-root state: %v", err)
+	}
+	if prev == setting {
+		log.Printf("data-root setting is not changed, noop")
+		return nil, nil
+	}
+	m := &dataRootMigration{setting: setting}
+	cur := currentDataRoot(daemonConfigPath)
+	if cur == target {
+		log.Printf("docker data-root is already %s", target)
+		return m, nil
+	}
+	fromEmpty, err := isDirEmpty(cur)
+	if err != nil {
+		return nil, err
+	}
+	toEmpty, err := isDirEmpty(target)
+	if err != nil {
+		return nil, err
+	}
+	action, err := planDataRootMigration(cur, target, fromEmpty, toEmpty, isResourceDisk(target))
+	if err != nil {
+		return nil, err
+	}
+	m.from, m.to = cur, target
+
+	reportProgress("Stopping docker-engine to relocate data-root from %s to %s", cur, target)
+	if err := d.StopDocker(); err != nil {
+		return nil, fmt.Errorf("error stopping docker-engine: %v", err)
+	}
+	if err := os.MkdirAll(target, 0711); err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", target, err)
+	}
+	switch action {
+	case dataRootFresh:
+		log.Printf("starting with an empty data-root at %s", target)
+	case dataRootReuse:
+		log.Printf("WARNING: %s already contains data, using it without migrating %s", target, cur)
+	case dataRootCopy:
+		reportProgress("Migrating docker data from %s to %s", cur, target)
+		if err := executil.ExecPipe("cp", "-a", cur+"/.", target+"/"); err != nil {
+			return nil, fmt.Errorf("error copying %s to %s: %v", cur, target, err)
+		}
+	}
+	return m, nil
+}
+
+// planDataRootMigration decides how the data is handled when the data-root is
+// relocated to a different location. The data is not copied if there is no
+// data at the old location, if there is already data at the new location, or
+// if the new location is on the resource disk.
+func planDataRootMigration(from, to string, fromEmpty, toEmpty, toResourceDisk bool) (dataRootAction, error) {
+	if within(to, from) || within(from, to) {
+		return 0, fmt.Errorf("cannot relocate data-root from %s to %s: one is inside the other", from, to)
+	}
+	switch {
+	case fromEmpty:
+		return dataRootFresh, nil
+	case !toEmpty:
+		return dataRootReuse, nil
+	case toResourceDisk:
+		return dataRootFresh, nil
+	}
+	return dataRootCopy, nil
+}
+
+// restartNeeded reports whether the engine needs to be restarted for the
+// relocation.
+func (m *dataRootMigration) restartNeeded() bool {
+	return m != nil && m.from != ""
+}
+
+// finish records the data-root setting once the engine is verified to be
+// working with it. The data at the old location is not removed, as it may
+// still be needed (e.g. if the engine is configured back to it).
+func (m *dataRootMigration) finish() error {
+	if m == nil {
+		return nil
+	}
+	if m.from != "" {
+		addWarning("docker data-root is relocated from %s to %s, the data at %s is left intact and can be removed once no longer needed", m.from, m.to, m.from)
+	}
+	if err := backup.Save(statefile.Path(dataRootState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(dataRootState, m.setting); err != nil {
+		return fmt.Errorf("error saving data-root state: %v", err)
+	}
+	return nil
+}
+
+// within reports whether path is inside dir.
+func within(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// isDirEmpty reports whether the directory does not exist or has no entries.
+func isDirEmpty(dir string) (bool, error) {
+	l, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error reading %s: %v", dir, err)
+	}
+	return len(l) == 0, nil
+}
+
+// isResourceDisk reports whether the given path is on the ephemeral resource
+// disk of the Azure VM, mounted by the guest agent or by cloud-init.
+func isResourceDisk(path string) bool {
+	mounts := mountPoints()
+	for _, mp := range []string{resourceDiskMountPoint(), "/mnt"} {
+		if mounts[mp] && (path == mp || within(path, mp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceDiskMountPoint returns the mount point of the resource disk
+// configured for the guest agent.
+func resourceDiskMountPoint() string {
+	f, err := os.Open(waagentConfig)
+	if err != nil {
+		return defaultResourceDiskMount
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(sc.Text()), "=", 2)
+		if len(kv) == 2 && kv[0] == "ResourceDisk.MountPoint" && kv[1] != "" {
+			return filepath.Clean(kv[1])
+		}
+	}
+	return defaultResourceDiskMount
+}
+
+// mountPoints returns the mount points on the system.
+func mountPoints() map[string]bool {
+	m := make(map[string]bool)
+	b, err := ioutil.ReadFile(procMounts)
+	if err != nil {
+		return m
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(l); len(f) > 1 {
+			m[f[1]] = true
+		}
+	}
+	return m
+}
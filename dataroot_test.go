@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_within(t *testing.T) {
+	for _, c := range []struct {
+		path, dir string
+		out       bool
+	}{
+		{"/mnt/resource/docker", "/mnt/resource", true},
+		{"/mnt/resource/docker", "/mnt/resource/", true},
+		{"/mnt/resource", "/mnt/resource", false},
+		{"/mnt/resourcedisk", "/mnt/resource", false},
+		{"/var/lib/docker", "/mnt", false},
+	} {
+		if out := within(c.path, c.dir); out != c.out {
+			t.Fatalf("got %v for within(%q, %q), expected: %v", out, c.path, c.dir, c.out)
+		}
+	}
+}
+
+func Test_isResourceDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(w, m string) { waagentConfig, procMounts = w, m }(waagentConfig, procMounts)
+	waagentConfig = filepath.Join(dir, "waagent.conf")
+	procMounts = filepath.Join(dir, "mounts")
+
+	if err := ioutil.WriteFile(procMounts, []byte(`/dev/sda1 / ext4 rw 0 0
+/dev/sdb1 /mnt/resource ext4 rw 0 0
+/dev/sdc1 /datadisk ext4 rw 0 0
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path string
+		out  bool
+	}{
+		{"/mnt/resource", true},
+		{"/mnt/resource/docker", true},
+		{"/mnt/docker", false}, // /mnt is not mounted
+		{"/datadisk/docker", false},
+		{"/var/lib/docker", false},
+	} {
+		if out := isResourceDisk(c.path); out != c.out {
+			t.Fatalf("got %v for %s, expected: %v", out, c.path, c.out)
+		}
+	}
+
+	// mount point configured for the agent
+	if err := ioutil.WriteFile(waagentConfig, []byte("ResourceDisk.Format=y\nResourceDisk.MountPoint=/datadisk\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isResourceDisk("/datadisk/docker") {
+		t.Fatal("expected /datadisk/docker to be on the resource disk")
+	}
+	if isResourceDisk("/mnt/resource/docker") {
+		t.Fatal("expected /mnt/resource/docker not to be on the resource disk")
+	}
+}
+
+func Test_planDataRootMigration(t *testing.T) {
+	for _, c := range []struct {
+		from, to                         string
+		fromEmpty, toEmpty, resourceDisk bool
+		out                              dataRootAction
+		ok                               bool
+	}{
+		{"/var/lib/docker", "/data/docker", false, true, false, dataRootCopy, true},
+		{"/var/lib/docker", "/data/docker", true, true, false, dataRootFresh, true},
+		{"/var/lib/docker", "/data/docker", false, false, false, dataRootReuse, true},
+		{"/var/lib/docker", "/mnt/docker", false, true, true, dataRootFresh, true},
+		{"/var/lib/docker", "/mnt/docker", false, false, true, dataRootReuse, true},
+		{"/data/docker", "/var/lib/docker", false, true, false, dataRootCopy, true},
+		{"/var/lib/docker", "/var/lib/docker/new", false, true, false, 0, false},
+		{"/data/docker", "/data", false, false, false, 0, false},
+	} {
+		out, err := planDataRootMigration(c.from, c.to, c.fromEmpty, c.toEmpty, c.resourceDisk)
+		if (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %+v, expected ok=%v", err, c, c.ok)
+		}
+		if c.ok && out != c.out {
+			t.Fatalf("got action %v for %+v, expected: %v", out, c, c.out)
+		}
+	}
+}
+
+func Test_effectiveDataRoot(t *testing.T) {
+	for _, c := range []struct {
+		cfg dockeropts.DaemonConfig
+		out string
+	}{
+		{dockeropts.DaemonConfig{}, defaultDataRoot},
+		{dockeropts.DaemonConfig{"data-root": "/data/docker/"}, "/data/docker"},
+		{dockeropts.DaemonConfig{"graph": "/data/docker"}, "/data/docker"},
+	} {
+		if out := effectiveDataRoot(c.cfg); out != c.out {
+			t.Fatalf("got %q for %v, expected: %q", out, c.cfg, c.out)
+		}
+	}
+}
+
+func Test_migrateDataRoot_settingNotChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = dir
+
+	// data-root set in daemon-config or options is not migrated
+	if m, err := migrateDataRoot(nil, filepath.Join(dir, "daemon.json"), "", "/data/docker"); err != nil {
+		t.Fatal(err)
+	} else if m != nil {
+		t.Fatalf("expected no migration, got: %+v", m)
+	}
+
+	if err := statefile.Set(dataRootState, "/data/docker"); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := migrateDataRoot(nil, filepath.Join(dir, "daemon.json"), "/data/docker", "/data/docker"); err != nil {
+		t.Fatal(err)
+	} else if m != nil {
+		t.Fatalf("expected no migration, got: %+v", m)
+	}
+}
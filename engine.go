@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/driver"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	engineDocker = "docker"
+	enginePodman = "podman"
+
+	// engineState keeps the engine set up by the extension, so that it is
+	// known when the settings are not available (e.g. upon uninstall).
+	engineState = "engine"
+)
+
+// engineDriver returns the driver of the container engine selected in the
+// settings, or of the engine set up previously if the settings cannot be
+// read. Scheduled operations always use the engine set up previously, as the
+// settings may have changed since.
+func engineDriver(d driver.DistroDriver, scheduled bool) (driver.DistroDriver, error) {
+	var engine string
+	if s, err := parseSettings(handlerEnv.HandlerEnvironment.ConfigFolder); err == nil && !scheduled {
+		engine = s.Docker.Engine
+	} else if _, err := statefile.Get(engineState, &engine); err != nil {
+		return nil, fmt.Errorf("error reading container engine: %v", err)
+	}
+	switch engine {
+	case "", engineDocker:
+		return d, nil
+	case enginePodman:
+		return driver.NewPodmanDriver(d)
+	}
+	return nil, fmt.Errorf("invalid docker.engine %q, expected %q or %q", engine, engineDocker, enginePodman)
+}
+
+// saveEngine records the container engine set up by the extension.
+func saveEngine(engine string) error {
+	if engine == "" {
+		engine = engineDocker
+	}
+	if err := backup.Save(statefile.Path(engineState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(engineState, engine); err != nil {
+		return fmt.Errorf("error saving container engine: %v", err)
+	}
+	return nil
+}
+
+// checkCompatEngineSettings returns error if settings of the docker daemon
+// without a counterpart in the given engine are specified.
+func checkCompatEngineSettings(s DockerHandlerSettings, ce driver.CompatEngine) error {
+	return checkUnsupported(ce.EngineName()+" engine", []namedSetting{
+		{"docker.port", s.Docker.Port != ""},
+		{"docker.listeners", len(s.Docker.Listeners) > 0},
+		{"docker.unix-socket", s.Docker.UnixSocket != unixSocketSettings{}},
+		{"docker.generate-certs", s.Docker.GenerateCerts},
+		{"docker.data-root", s.Docker.DataRoot != ""},
+		{"docker.userns-remap", s.Docker.UsernsRemap != ""},
+		{"docker-users", len(s.DockerUsers) > 0},
+		{"docker-group-gid", s.DockerGroupGID != 0},
+		{"certs", s.Certs.HasDockerCerts()},
+		{"rootless", s.Rootless.Enabled},
+		{"swarm", s.Swarm.Mode != ""},
+		{"plugins", len(s.Plugins) > 0},
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	dockerGroup      = "docker"
+	dockerSocketPath = "/var/run/docker.sock"
+
+	dockerUsersState = "docker-users" // users added to the docker group by the extension
+)
+
+// dockerUsers returns the users to be added to the docker group: the users in
+// the docker-users setting, or the provisioned admin user of the VM if the
+// setting is not specified (and the admin user is known).
+func dockerUsers(s DockerHandlerSettings, adminUser string) ([]string, error) {
+	if s.DockerUsers == nil {
+		if adminUser == "" {
+			return nil, nil
+		}
+		return []string{adminUser}, nil
+	}
+	var out []string
+	seen := make(map[string]bool)
+	for _, u := range s.DockerUsers {
+		if !userNameRegexp.MatchString(u) {
+			return nil, fmt.Errorf("invalid user name %q in docker-users", u)
+		}
+		if !seen[u] {
+			seen[u] = true
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+// setupDockerGroup creates the docker group if it does not exist. If gid is
+// not 0, the group is created with (or an existing group is changed to) that
+// GID so that it is consistent across the VMs.
+func setupDockerGroup(gid int) error {
+	if gid < 0 {
+		return fmt.Errorf("invalid docker-group-gid %d", gid)
+	}
+	g, err := user.LookupGroup(dockerGroup)
+	if err != nil {
+		args := []string{"--system"}
+		if gid != 0 {
+			args = append(args, "--gid", strconv.Itoa(gid))
+		}
+		log.Printf("Creating group %s", dockerGroup)
+		if out, err := executil.Exec("groupadd", append(args, dockerGroup)...); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("error creating group %s: %v", dockerGroup, err)
+		}
+		return nil
+	}
+	if gid == 0 || g.Gid == strconv.Itoa(gid) {
+		return nil
+	}
+
+	log.Printf("Changing GID of group %s from %s to %d", dockerGroup, g.Gid, gid)
+	if out, err := executil.Exec("groupmod", "--gid", strconv.Itoa(gid), dockerGroup); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("error changing GID of group %s to %d: %v", dockerGroup, gid, err)
+	}
+	// the socket keeps the old GID until it is recreated
+	if err := os.Chown(dockerSocketPath, -1, gid); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error changing group of %s: %v", dockerSocketPath, err)
+	}
+	addWarning("GID of group %s is changed from %s to %d, files owned by the old GID are not updated", dockerGroup, g.Gid, gid)
+	return nil
+}
+
+// updateDockerGroupMembers adds the users to the docker group and removes the
+// users added by the extension previously but no longer listed. Users that
+// were already members of the group are never removed.
+func updateDockerGroupMembers(users []string) error {
+	var prev []string
+	if _, err := statefile.Get(dockerUsersState, &prev); err != nil {
+		return fmt.Errorf("error reading docker group members: %v", err)
+	}
+
+	for _, u := range removedUsers(prev, users) {
+		if _, err := user.Lookup(u); err != nil {
+			log.Printf("user %s no longer exists, skipping", u)
+			continue
+		}
+		log.Printf("Removing user %s from group %s", u, dockerGroup)
+		if out, err := executil.Exec("gpasswd", "--delete", u, dockerGroup); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("error removing user %s from group %s: %v", u, dockerGroup, err)
+		}
+	}
+
+	var added []string
+	for _, u := range users {
+		if contains(prev, u) {
+			added = append(added, u)
+			continue
+		}
+		member, err := inGroup(u, dockerGroup)
+		if err != nil {
+			return err
+		}
+		if member {
+			log.Printf("user %s is already in group %s", u, dockerGroup)
+			continue
+		}
+		log.Printf("Adding user %s to group %s", u, dockerGroup)
+		if out, err := executil.Exec("usermod", "-aG", dockerGroup, u); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("error adding user %s to group %s: %v", u, dockerGroup, err)
+		}
+		added = append(added, u)
+	}
+	sort.Strings(added)
+
+	if err := backup.Save(statefile.Path(dockerUsersState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(dockerUsersState, added); err != nil {
+		return fmt.Errorf("error saving docker group members: %v", err)
+	}
+	return nil
+}
+
+// removedUsers returns the users in prev that are not in users.
+func removedUsers(prev, users []string) []string {
+	var out []string
+	for _, u := range prev {
+		if !contains(users, u) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// inGroup reports whether the existing user is a member of the group.
+func inGroup(userName, group string) (bool, error) {
+	if _, err := user.Lookup(userName); err != nil {
+		return false, fmt.Errorf("cannot find user %s: %v", userName, err)
+	}
+	out, err := executil.Exec("id", "-nG", userName)
+	if err != nil {
+		return false, fmt.Errorf("error getting groups of user %s: %v", userName, err)
+	}
+	return contains(strings.Fields(string(out)), group), nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,66 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/Azure/azure-docker-extension/pkg/util"
 	"github.com/Azure/azure-docker-extension/pkg/vmextension"
 )
 
 // publicSettings is the type deserialized from public configuration section.
 type publicSettings struct {
-	Docker      dockerEngineSettings   `json:"docker"`
-	ComposeJson map[string]interface{} `json:"compose"`
-	ComposeEnv  map[string]string      `json:"compose-environment"`
-	AzureEnv    string                 `json:"azure-environment"`
+	Docker           dockerEngineSettings   `json:"docker"`
+	ComposeJson      map[string]interface{} `json:"compose"`
+	ComposeEnv       map[string]string      `json:"compose-environment"`
+	AzureEnv         string                 `json:"azure-environment"`
+	RestoreOnDisable bool                   `json:"restore-config-on-disable"`
+	OfflineInstall   offlineInstallSettings `json:"offline-install"`
+	Proxy            proxySettings          `json:"proxy"`
+	ClientContext    clientContextSettings  `json:"client-context"`
+	DockerUsers      []string               `json:"docker-users"`
+	DockerGroupGID   int                    `json:"docker-group-gid"`
+	Rootless         rootlessSettings       `json:"rootless"`
+	Swarm            swarmSettings          `json:"swarm"`
+	Plugins          []pluginSettings       `json:"plugins"`
+	Images           imagesSettings         `json:"images"`
+	Prune            pruneSettings          `json:"prune"`
+}
+
+type imagesSettings struct {
+	Pull        []string `json:"pull"`
+	Load        []string `json:"load"`
+	Parallelism int      `json:"parallelism"`
+	Retries     *int     `json:"retries"` // nil for the default, 0 disables retries
+}
+
+type pruneSettings struct {
+	Schedule string   `json:"schedule"`
+	Filters  []string `json:"filters"`
+	Volumes  bool     `json:"volumes"`
+}
+
+type pluginSettings struct {
+	Reference           string            `json:"reference"`
+	Alias               string            `json:"alias"`
+	Settings            map[string]string `json:"settings"`
+	GrantAllPermissions bool              `json:"grant-all-permissions"`
+}
+
+type swarmSettings struct {
+	Mode             string   `json:"mode"`
+	Managers         []string `json:"managers"`
+	AdvertiseAddress string   `json:"advertise-address"`
+}
+
+type rootlessSettings struct {
+	Enabled bool   `json:"enabled"`
+	User    string `json:"user"`
+}
+
+type clientContextSettings struct {
+	Name    string `json:"name"`
+	Host    string `json:"host"`
+	Default bool   `json:"default"`
 }
 
 // protectedSettings is the type decoded and deserialized from protected
@@ -20,11 +70,67 @@ type protectedSettings struct {
 	Certs               dockerCertSettings  `json:"certs"`
 	Login               dockerLoginSettings `json:"login"`
 	ComposeProtectedEnv map[string]string   `json:"environment"`
+	ProxyCredentials    proxyCredentials    `json:"proxy-credentials"`
+	RegistryCAs         map[string]string   `json:"registry-cas"`
+	ClientCerts         dockerCertSettings  `json:"client-certs"`
+	SwarmJoinToken      string              `json:"swarm-join-token"`
 }
 
 type dockerEngineSettings struct {
-	Port    string   `json:"port"`
-	Options []string `json:"options"`
+	Engine        string                 `json:"engine"`
+	Port          string                 `json:"port"`
+	GenerateCerts bool                   `json:"generate-certs"`
+	Listeners     []string               `json:"listeners"`
+	UnixSocket    unixSocketSettings     `json:"unix-socket"`
+	Policy        string                 `json:"policy"`
+	Options       []string               `json:"options"`
+	DaemonConfig  map[string]interface{} `json:"daemon-config"`
+	Version       string                 `json:"version"`
+	InstallSource installSourceSettings  `json:"install-source"`
+
+	RegistryMirrors    []string `json:"registry-mirrors"`
+	InsecureRegistries []string `json:"insecure-registries"`
+	DataRoot           string   `json:"data-root"`
+	UsernsRemap        string   `json:"userns-remap"`
+
+	Logging loggingSettings `json:"logging"`
+}
+
+type loggingSettings struct {
+	Driver  string            `json:"driver"`
+	MaxSize string            `json:"max-size"`
+	MaxFile int               `json:"max-file"`
+	Options map[string]string `json:"options"`
+}
+
+func (l loggingSettings) IsSet() bool {
+	return l.Driver != "" || l.MaxSize != "" || l.MaxFile != 0 || len(l.Options) > 0
+}
+
+type unixSocketSettings struct {
+	Group string `json:"group"`
+	Mode  string `json:"mode"`
+}
+
+type installSourceSettings struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type offlineInstallSettings struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+}
+
+type proxySettings struct {
+	HTTP    string `json:"http"`
+	HTTPS   string `json:"https"`
+	NoProxy string `json:"no-proxy"`
+}
+
+type proxyCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type dockerLoginSettings struct {
@@ -44,6 +150,33 @@ func (e dockerCertSettings) HasDockerCerts() bool {
 	return e.CABase64 != "" && e.ServerKeyBase64 != "" && e.ServerCertBase64 != ""
 }
 
+// hasTLS reports whether the docker engine is configured with TLS, with the
+// certs provided in the settings or generated by the extension.
+func (s DockerHandlerSettings) hasTLS() bool {
+	return s.Certs.HasDockerCerts() || s.Docker.GenerateCerts
+}
+
+// namedSetting is a setting and whether it is specified.
+type namedSetting struct {
+	name string
+	set  bool
+}
+
+// checkUnsupported returns error listing the specified settings, which are
+// not supported in the given mode.
+func checkUnsupported(mode string, l []namedSetting) error {
+	var names []string
+	for _, s := range l {
+		if s.set {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("settings not supported in %s: %s", mode, strings.Join(names, ", "))
+	}
+	return nil
+}
+
 func (e dockerLoginSettings) HasLoginInfo() bool {
 	return e.Username != "" && e.Password != ""
 }
@@ -53,6 +186,23 @@ type DockerHandlerSettings struct {
 	protectedSettings
 }
 
+// proxyConfig returns the proxy configuration with the credentials from the
+// protected settings, or returns error if the proxy settings are invalid.
+func (s DockerHandlerSettings) proxyConfig() (util.ProxyConfig, error) {
+	p := util.ProxyConfig{
+		HTTP:    s.Proxy.HTTP,
+		HTTPS:   s.Proxy.HTTPS,
+		NoProxy: s.Proxy.NoProxy,
+	}
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	if s.ProxyCredentials.Username != "" && !p.IsSet() {
+		return p, fmt.Errorf("proxy credentials are specified but no proxy is configured")
+	}
+	return p.WithCredentials(s.ProxyCredentials.Username, s.ProxyCredentials.Password)
+}
+
 func parseSettings(configFolder string) (*DockerHandlerSettings, error) {
 	pubSettingsJSON, protSettingsJSON, err := vmextension.ReadSettings(configFolder)
 	if err != nil {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+)
+
+const (
+	defaultPullParallelism = 3
+	maxPullParallelism     = 10
+	defaultPullRetries     = 3
+	pullRetryInterval      = 10 * time.Second
+)
+
+// imageArchiveExts are the extensions of the image archives loaded from the
+// directories in images.load.
+var imageArchiveExts = []string{".tar", ".tar.gz", ".tgz"}
+
+// prepareImages loads the image archives and pulls the images in the settings,
+// so that compose does not need to pull them. Progress is reported in the
+// extension status.
+func prepareImages(s imagesSettings) error {
+	parallelism, retries, err := pullOptions(s)
+	if err != nil {
+		return err
+	}
+	if len(s.Load) == 0 && len(s.Pull) == 0 {
+		log.Printf("images not specified, noop")
+		return nil
+	}
+	if err := loadImages(s.Load); err != nil {
+		return err
+	}
+	return pullImages(s.Pull, parallelism, retries)
+}
+
+// pullOptions returns the pull parallelism and retries in the settings, or
+// their defaults if not specified.
+func pullOptions(s imagesSettings) (parallelism, retries int, err error) {
+	parallelism, retries = s.Parallelism, defaultPullRetries
+	if parallelism == 0 {
+		parallelism = defaultPullParallelism
+	}
+	if s.Retries != nil {
+		retries = *s.Retries
+	}
+	if parallelism < 0 || parallelism > maxPullParallelism {
+		return 0, 0, fmt.Errorf("images.parallelism should be between 1 and %d", maxPullParallelism)
+	}
+	if retries < 0 {
+		return 0, 0, fmt.Errorf("invalid images.retries %d", retries)
+	}
+	return parallelism, retries, nil
+}
+
+// loadImages loads the image archives at the given paths, which are archive
+// files or directories of archives.
+func loadImages(paths []string) error {
+	files, err := imageArchives(paths)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		reportProgress("Loading image archive %d/%d: %s", i+1, len(files), f)
+		out, err := executil.Exec("docker", "load", "--input", f)
+		log.Printf("%s", strings.TrimSpace(string(out)))
+		if err != nil {
+			return fmt.Errorf("'docker load' failed for %s: %v", f, err)
+		}
+	}
+	return nil
+}
+
+// imageArchives returns the archive files at the given paths in order, with
+// the archives in a directory sorted by name.
+func imageArchives(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find image archive: %v", err)
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		var l []string
+		for _, ext := range imageArchiveExts {
+			m, err := filepath.Glob(filepath.Join(p, "*"+ext))
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, m...)
+		}
+		sort.Strings(l)
+		files = append(files, l...)
+	}
+	return files, nil
+}
+
+// pullImages pulls the images with at most the given number of pulls in
+// parallel, retrying failed pulls. A failed pull is reported as a warning if
+// the image already exists locally.
+func pullImages(images []string, parallelism, retries int) error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		done   int
+		failed []string
+		sem    = make(chan struct{}, parallelism)
+	)
+	reportProgress("Pulling %d images", len(images))
+	for _, img := range images {
+		wg.Add(1)
+		go func(img string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			err := pullImage(img, retries)
+			<-sem
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				if _, ierr := executil.Exec("docker", "image", "inspect", img); ierr == nil {
+					addWarning("pulling image %s failed, using the existing image: %v", img, err)
+				} else {
+					failed = append(failed, fmt.Sprintf("%s (%v)", img, err))
+				}
+			}
+			reportProgress("Pulled %d/%d images", done, len(images))
+		}(img)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to pull images: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func pullImage(img string, retries int) error {
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			log.Printf("pulling %s failed (%v), retrying in %s", img, err, pullRetryInterval)
+			time.Sleep(pullRetryInterval)
+		}
+		var out []byte
+		if out, err = executil.Exec("docker", "pull", "--quiet", img); err == nil {
+			log.Printf("Pulled image %s", img)
+			return nil
+		}
+		err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
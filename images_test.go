@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_pullOptions(t *testing.T) {
+	intp := func(i int) *int { return &i }
+	for _, c := range []struct {
+		in                   imagesSettings
+		parallelism, retries int
+		ok                   bool
+	}{
+		{imagesSettings{}, defaultPullParallelism, defaultPullRetries, true},
+		{imagesSettings{Parallelism: 5, Retries: intp(1)}, 5, 1, true},
+		{imagesSettings{Retries: intp(0)}, defaultPullParallelism, 0, true}, // retries disabled
+		{imagesSettings{Parallelism: maxPullParallelism}, maxPullParallelism, defaultPullRetries, true},
+		{imagesSettings{Parallelism: maxPullParallelism + 1}, 0, 0, false},
+		{imagesSettings{Parallelism: -1}, 0, 0, false},
+		{imagesSettings{Retries: intp(-1)}, 0, 0, false},
+	} {
+		parallelism, retries, err := pullOptions(c.in)
+		if (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %+v, expected ok=%v", err, c.in, c.ok)
+		}
+		if c.ok && (parallelism != c.parallelism || retries != c.retries) {
+			t.Fatalf("got parallelism=%d retries=%d for %+v, expected: %d %d", parallelism, retries, c.in, c.parallelism, c.retries)
+		}
+	}
+}
+
+func Test_imageArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"b.tgz", "a.tar.gz", "c.tar", "readme.txt", "other/d.tar"} {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// archives in a directory are sorted by name regardless of the extension,
+	// files and directories keep the specified order
+	files, err := imageArchives([]string{filepath.Join(dir, "other", "d.tar"), dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "other", "d.tar"),
+		filepath.Join(dir, "a.tar.gz"),
+		filepath.Join(dir, "b.tgz"),
+		filepath.Join(dir, "c.tar"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("got %v, expected: %v", files, expected)
+	}
+
+	if _, err := imageArchives([]string{filepath.Join(dir, "missing.tar")}); err == nil {
+		t.Fatal("expected error for missing archive")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/imds"
+)
+
+// privateIPHost is the placeholder in listener addresses replaced with the
+// primary private IP address of the VM.
+const privateIPHost = "private-ip"
+
+// listenerArgs returns the -H flags for the listeners configured in the
+// settings in addition to the base listener, skipping duplicates and the
+// default unix socket (which the base options of the distro already provide).
+func listenerArgs(s dockerEngineSettings, optHosts []string) ([]string, error) {
+	var args []string
+	seen := make(map[string]bool)
+	if s.Port != "" {
+		args = append(args, fmt.Sprintf("-H=0.0.0.0:%s", s.Port))
+		seen["tcp://0.0.0.0:"+s.Port] = true
+	}
+
+	var privateIP string
+	for _, l := range append(append([]string{}, s.Listeners...), optHosts...) {
+		l = normalizeListener(l)
+		if strings.Contains(l, "://"+privateIPHost+":") {
+			if privateIP == "" {
+				ip, err := primaryPrivateIP()
+				if err != nil {
+					return nil, fmt.Errorf("cannot get private IP address of the VM for listener %s: %v", l, err)
+				}
+				privateIP = ip
+			}
+			l = strings.Replace(l, "://"+privateIPHost+":", "://"+privateIP+":", 1)
+		}
+		if err := dockeropts.ValidateListener(l); err != nil {
+			return nil, err
+		}
+		if dockeropts.IsDefaultListener(l) || seen[l] {
+			continue
+		}
+		seen[l] = true
+		args = append(args, fmt.Sprintf("-H=%s", l))
+	}
+	return args, nil
+}
+
+// primaryPrivateIP returns the primary private IP address of the VM from the
+// instance metadata service.
+func primaryPrivateIP() (string, error) {
+	n, err := imds.GetNetwork()
+	if err != nil {
+		return "", err
+	}
+	ips := n.PrivateIPs()
+	if len(ips) == 0 {
+		return "", errors.New("no private IP address in instance metadata")
+	}
+	return ips[0], nil
+}
+
+// unixSocketConfig validates the unix socket settings and returns the socket
+// group and mode (0 if not specified).
+func unixSocketConfig(s unixSocketSettings) (string, os.FileMode, error) {
+	var mode os.FileMode
+	if s.Mode != "" {
+		m, err := strconv.ParseUint(s.Mode, 8, 32)
+		if err != nil || m > 0777 {
+			return "", 0, fmt.Errorf("invalid unix socket mode %q, expected octal permissions such as 0660", s.Mode)
+		}
+		if m&0007 != 0 {
+			addWarning("unix socket mode %s gives all users on the VM root access through docker", s.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+	if s.Group != "" {
+		if _, err := user.LookupGroup(s.Group); err != nil {
+			return "", 0, fmt.Errorf("invalid unix socket group %q: %v", s.Group, err)
+		}
+	}
+	return s.Group, mode, nil
+}
+
+// normalizeListener adds the tcp:// scheme to the listeners specified as
+// host:port, which dockerd accepts as well.
+func normalizeListener(l string) string {
+	if !strings.Contains(l, "://") && strings.Contains(l, ":") {
+		return "tcp://" + l
+	}
+	return l
+}
@@ -22,23 +22,27 @@ const (
 )
 
 var (
-	log        *lg.Logger
+	log        = lg.New(os.Stderr, "[DockerExtension] ", lg.LstdFlags)
 	handlerEnv vmextension.HandlerEnvironment
 	seqNum     = -1
+	seqNumErr  error
 	out        io.Writer
+	currentOp  Op
+	warnings   []string // reported in the status upon success
 )
 
-func init() {
+// initEnv reads the handler environment and sets up the logger to write to the
+// log file of the extension.
+func initEnv() {
 	// Read extension handler environment
 	var err error
 	handlerEnv, err = vmextension.GetHandlerEnv()
 	if err != nil {
 		lg.Fatalf("ERROR: Cannot load handler environment: %v", err)
 	}
-	seqNum, err = vmextension.FindSeqNum(handlerEnv.HandlerEnvironment.ConfigFolder)
-	if err != nil {
-		lg.Fatalf("ERROR: cannot find seqnum: %v", err)
-	}
+	// checked once the operation is known, scheduled operations do not need
+	// the settings
+	seqNum, seqNumErr = vmextension.FindSeqNum(handlerEnv.HandlerEnvironment.ConfigFolder)
 
 	// Update logger to write to logfile
 	ld := handlerEnv.HandlerEnvironment.LogFolder
@@ -55,7 +59,8 @@ func init() {
 }
 
 func main() {
-	log.Printf(strings.Repeat("-", 40))
+	initEnv()
+	log.Print(strings.Repeat("-", 40))
 	log.Printf("Extension handler launch args: %#v", strings.Join(os.Args, " "))
 	if len(os.Args) <= 1 {
 		ops := []string{}
@@ -69,27 +74,15 @@ func main() {
 	if !ok {
 		log.Fatalf("ERROR: Invalid operation provided: '%s'", opStr)
 	}
-	log.Printf("seqnum: %d", seqNum)
-
-	// seqnum check: waagent invokes enable twice with the same seqnum, so exit the process
-	// started later. Refuse proceeding if seqNum is smaller or the same than the one running.
-	if seqExists, seq, err := seqnumfile.Get(); err != nil {
-		log.Fatalf("ERROR: seqnumfile could not be read: %v", err)
-	} else if seqExists {
-		if seq == seqNum {
-			log.Printf("WARNING: Another instance of the extension handler with the same seqnum (=%d) is currently active according to .seqnum file.", seq)
-			log.Println("Exiting gracefully with exitcode 0, not reporting to .status file.")
-			os.Exit(0)
-		} else if seq > seqNum {
-			log.Printf("WARNING: Another instance of the extension handler with a higher seqnum (%d > %d) is currently active according to .seqnum file. The smaller seqnum will not proceed.", seq, seqNum)
-			log.Println("Exiting gracefully with exitcode 0, not reporting to .status file.")
-			os.Exit(0)
-		}
+	if seqNumErr != nil && !op.scheduled {
+		log.Fatalf("ERROR: cannot find seqnum: %v", seqNumErr)
 	}
+	log.Printf("seqnum: %d", seqNum)
 
-	// create .seqnum file
-	if err := seqnumfile.Set(seqNum); err != nil {
-		log.Fatalf("Error seting seqnum file: %v", err)
+	// scheduled operations run independently of the agent, which may be
+	// running another operation at the same time
+	if !op.scheduled {
+		acquireSeqNum()
 	}
 
 	var fail = func(format string, args ...interface{}) {
@@ -110,6 +103,9 @@ func main() {
 	if err != nil {
 		fail("ERROR: %v", err)
 	}
+	if dd, err = engineDriver(dd, op.scheduled); err != nil {
+		fail("ERROR: %v", err)
+	}
 	log.Printf("using distro driver: %T", dd)
 
 	if u, err := user.Current(); err != nil {
@@ -120,13 +116,48 @@ func main() {
 	log.Printf("env['PATH'] = %s", os.Getenv("PATH"))
 
 	log.Printf("+ starting: '%s'", opStr)
+	currentOp = op
 	if err = op.f(handlerEnv, dd); err != nil {
 		fail("ERROR: %v", err)
 	}
 	log.Printf("- completed: '%s'", opStr)
-	reportStatus(status.StatusSuccess, op, "")
+	msg := ""
+	if len(warnings) > 0 {
+		msg = fmt.Sprintf("%s succeeded with warnings: %s", op.name, strings.Join(warnings, "; "))
+	}
+	reportStatus(status.StatusSuccess, op, msg)
+	if !op.scheduled {
+		releaseSeqNum()
+	}
+}
 
-	// clear .seqnum file
+// acquireSeqNum creates the .seqnum file, or exits if another instance of the
+// extension handler with the same or a higher seqnum is running.
+func acquireSeqNum() {
+	// seqnum check: waagent invokes enable twice with the same seqnum, so exit the process
+	// started later. Refuse proceeding if seqNum is smaller or the same than the one running.
+	if seqExists, seq, err := seqnumfile.Get(); err != nil {
+		log.Fatalf("ERROR: seqnumfile could not be read: %v", err)
+	} else if seqExists {
+		if seq == seqNum {
+			log.Printf("WARNING: Another instance of the extension handler with the same seqnum (=%d) is currently active according to .seqnum file.", seq)
+			log.Println("Exiting gracefully with exitcode 0, not reporting to .status file.")
+			os.Exit(0)
+		} else if seq > seqNum {
+			log.Printf("WARNING: Another instance of the extension handler with a higher seqnum (%d > %d) is currently active according to .seqnum file. The smaller seqnum will not proceed.", seq, seqNum)
+			log.Println("Exiting gracefully with exitcode 0, not reporting to .status file.")
+			os.Exit(0)
+		}
+	}
+
+	// create .seqnum file
+	if err := seqnumfile.Set(seqNum); err != nil {
+		log.Fatalf("Error seting seqnum file: %v", err)
+	}
+}
+
+// releaseSeqNum clears the .seqnum file.
+func releaseSeqNum() {
 	if err := seqnumfile.Delete(); err != nil {
 		log.Printf("WARNING: Error deleting seqnumfile: %v", err)
 	}
@@ -154,16 +185,33 @@ func reportStatus(t status.Type, op Op, msg string) error {
 	return s.Save(dir, seqNum)
 }
 
+// reportProgress reports the progress of the running operation as a
+// transitioning status with the given message.
+func reportProgress(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	if err := reportStatus(status.StatusTransitioning, currentOp, msg); err != nil {
+		log.Printf("Error reporting extension status: %v", err)
+	}
+}
+
+// addWarning logs the warning and adds it to the status message reported
+// when the operation succeeds.
+func addWarning(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("WARNING: %s", msg)
+	warnings = append(warnings, msg)
+}
+
 // logFail prints the failure, reports failure status and exits
 func logFail(op Op, msg string) {
-	log.Printf(msg)
+	log.Print(msg)
 	if err := reportStatus(status.StatusError, op, msg); err != nil {
 		log.Printf("Error reporting extension status: %v", err)
 	}
-	if err := seqnumfile.Delete(); err != nil {
-		log.Printf("WARNING: Error deleting seqnumfile: %v", err)
+	if !op.scheduled {
+		releaseSeqNum()
 	}
-	log.Println("Cleaned up .seqnum file.")
 	log.Println("Exiting with code 1.")
 	os.Exit(1)
 }
@@ -10,6 +10,29 @@ func disable(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 	if err := d.StopDocker(); err != nil {
 		return err
 	}
+	if err := stopRootless(); err != nil {
+		return err
+	}
 	log.Printf("-- stop docker daemon")
+
+	// restored by enable
+	log.Printf("++ remove prune schedule")
+	if err := updatePruneSchedule(d, pruneSettings{}); err != nil {
+		return err
+	}
+	log.Printf("-- remove prune schedule")
+
+	// disable does not depend on the settings otherwise, so they are only
+	// read to decide whether to restore the configuration
+	settings, err := parseSettings(he.HandlerEnvironment.ConfigFolder)
+	if err != nil {
+		log.Printf("WARNING: cannot read settings, not restoring docker configuration: %v", err)
+	} else if settings.RestoreOnDisable {
+		log.Printf("++ restore docker configuration")
+		if err := restoreDockerConfig(d); err != nil {
+			return err
+		}
+		log.Printf("-- restore docker configuration")
+	}
 	return nil
 }
@@ -7,14 +7,20 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
 	"github.com/Azure/azure-docker-extension/pkg/driver"
 	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/pkgbundle"
+	"github.com/Azure/azure-docker-extension/pkg/tlsutil"
 	"github.com/Azure/azure-docker-extension/pkg/util"
 	"github.com/Azure/azure-docker-extension/pkg/vmextension"
 
@@ -24,8 +30,15 @@ import (
 const (
 	composeUrlGlobal     = "https://github.com/docker/compose/releases/download/1.6.2/docker-compose-Linux-x86_64"
 	composeUrlAzureChina = "https://mirror.azure.cn/docker-toolbox/linux/compose/1.6.2/docker-compose-Linux-x86_64"
-	composeBin         = "docker-compose"
-	composeTimeoutSecs = 600
+	dockerRepoAzureChina = "https://mirror.azure.cn/docker-ce"
+	composeBin           = "docker-compose"
+	composeTimeoutSecs   = 600
+
+	offlineBundleDir = "packages" // in extension directory
+
+	dockerHealthCheckRetries  = 30
+	dockerHealthCheckInterval = 2 * time.Second
+	daemonLogExcerptLines     = 10
 
 	composeYml     = "docker-compose.yml"
 	composeYmlDir  = "/etc/docker/compose"
@@ -43,90 +56,218 @@ func enable(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 		return err
 	}
 
-	dockerInstallCmd := ""
+	src := driver.PackageSource{
+		Type: settings.Docker.InstallSource.Type,
+		URL:  settings.Docker.InstallSource.URL,
+	}
+	if src.Type == "" {
+		src.Type = driver.SourceDockerCE
+	}
 	composeUrl := ""
 	switch settings.AzureEnv {
-		case "AzureChinaCloud":
-			dockerInstallCmd = "curl -sSL https://mirror.azure.cn/repo/install-docker-engine.sh | sh -s -- --mirror AzureChinaCloud"
-			composeUrl = composeUrlAzureChina
-		case "AzureCloud", "":
-			dockerInstallCmd = "curl -sSL https://get.docker.com/ | sh"
-			composeUrl = composeUrlGlobal
-		default:
-			return fmt.Errorf("invalid environment name: %s", settings.AzureEnv)
+	case "AzureChinaCloud":
+		// other engines are installed from the distro repositories
+		if _, compat := d.(driver.CompatEngine); !compat && src.Type == driver.SourceDockerCE && src.URL == "" {
+			src.URL = dockerRepoAzureChina
+		}
+		composeUrl = composeUrlAzureChina
+	case "AzureCloud", "":
+		composeUrl = composeUrlGlobal
+	default:
+		return fmt.Errorf("invalid environment name: %s", settings.AzureEnv)
+	}
+	if err := src.Validate(); err != nil {
+		return err
+	}
+	proxy, err := settings.proxyConfig()
+	if err != nil {
+		return fmt.Errorf("invalid proxy settings: %v", err)
+	}
+	setupProxy(proxy)
+
+	// Use the Docker-compatible API of the engine instead of docker
+	engineBin := "docker"
+	ce, compat := d.(driver.CompatEngine)
+	if compat {
+		if err := checkCompatEngineSettings(*settings, ce); err != nil {
+			return err
+		}
+		engineBin = ce.EngineName()
+		os.Setenv("DOCKER_HOST", ce.DockerHost())
+	}
+	if err := saveEngine(settings.Docker.Engine); err != nil {
+		return err
+	}
+
+	// Load offline installation bundle
+	var bundle *pkgbundle.Bundle
+	if settings.OfflineInstall.Enabled {
+		log.Printf("++ load offline installation bundle")
+		if bundle, err = loadBundle(settings.OfflineInstall.Path); err != nil {
+			return fmt.Errorf("error loading offline installation bundle: %v", err)
+		}
+		log.Printf("-- load offline installation bundle")
 	}
 
 	// Install docker daemon
 	log.Printf("++ install docker")
-	if _, err := exec.LookPath("docker"); err == nil {
-		log.Printf("docker already installed. not re-installing")
-	} else {
-		// TODO(ahmetb) Temporary retry logic around installation for serialization
-		// problem in Azure VM Scale Sets. In case of scale-up event, the new VM with
-		// multiple extensions (such as Linux Diagnostics and Docker Extension) will install
-		// the extensions in parallel and that will result in non-deterministic
-		// acquisition of dpkg lock (apt-get install) and thus causing one of the
-		// extensions to fail.
-		//
-		// Adding this temporary retry logic just for Linux Diagnostics extension
-		// assuming it will take at most 5 minutes to be done with apt-get lock.
-		//
-		// This retry logic should be removed once the issue is fixed on the resource
-		// provider layer.
-
-		var (
-			nRetries      = 6
-			retryInterval = time.Minute * 1
-		)
-
-		for nRetries > 0 {
-			if err := d.InstallDocker(dockerInstallCmd); err != nil {
-				nRetries--
-				if nRetries == 0 {
-					return err
-				}
-				log.Printf("install failed. remaining attempts=%d. error=%v", nRetries, err)
-				log.Printf("sleeping %s", retryInterval)
-				time.Sleep(retryInterval)
-			} else {
-				break
-			}
+	if _, err := exec.LookPath(engineBin); err == nil {
+		log.Printf("%s already installed. not re-installing", engineBin)
+	} else if bundle != nil {
+		pkgs := bundle.Paths(".deb", ".rpm")
+		log.Printf("installing docker from offline installation bundle: %v", pkgs)
+		if err := d.InstallDockerPackages(pkgs); err != nil {
+			return err
 		}
+	} else if err := withInstallRetries(func() error { return d.InstallDocker(src) }); err != nil {
+		return err
 	}
 	log.Printf("-- install docker")
 
+	// Install the pinned docker version
+	log.Printf("++ install docker version")
+	if err := installDockerVersion(d, src, settings.Docker.Version, bundle != nil); err != nil {
+		return fmt.Errorf("error installing docker version %s: %v", settings.Docker.Version, err)
+	}
+	log.Printf("-- install docker version")
+
 	// Install docker-compose
 	log.Printf("++ install docker-compose")
-	if err := installCompose(composeBinPath(d), composeUrl); err != nil {
+	if err := installCompose(composeBinPath(d), composeUrl, bundle); err != nil {
 		return fmt.Errorf("error installing docker-compose: %v", err)
 	}
 	log.Printf("-- install docker-compose")
 
-	// Add user to 'docker' group to user docker as non-root
+	// Add users to 'docker' group to use docker as non-root
 	u, err := util.GetAzureUser()
 	if err != nil {
-		return fmt.Errorf("failed to get provisioned user: %v", err)
+		log.Printf("WARNING: failed to get provisioned user: %v", err)
+		u = ""
+	}
+	if settings.Rootless.Enabled {
+		return enableRootless(he, d, *settings, u, src, bundle != nil)
 	}
-	log.Printf("++ add user to docker group")
-	if out, err := executil.Exec("usermod", "-aG", "docker", u); err != nil {
-		log.Printf("%s", string(out))
+	if err := teardownRootless(d, ""); err != nil {
 		return err
 	}
-	log.Printf("-- add user to docker group")
+
+	log.Printf("++ add users to docker group")
+	if compat {
+		log.Printf("%s API socket is only accessible by root, docker group is not used", engineBin)
+	} else {
+		users, err := dockerUsers(*settings, u)
+		if err != nil {
+			return err
+		}
+		if err := setupDockerGroup(settings.DockerGroupGID); err != nil {
+			return err
+		}
+		if err := updateDockerGroupMembers(users); err != nil {
+			return err
+		}
+	}
+	log.Printf("-- add users to docker group")
 
 	// Install docker remote access certs
 	log.Printf("++ setup docker certs")
-	if err := installDockerCerts(*settings, dockerCfgDir); err != nil {
-		return fmt.Errorf("error installing docker certs: %v", err)
+	if settings.Certs.HasDockerCerts() || !settings.Docker.GenerateCerts {
+		if err := installDockerCerts(*settings, dockerCfgDir); err != nil {
+			return fmt.Errorf("error installing docker certs: %v", err)
+		}
+	} else if err := generateDockerCerts(dockerCfgDir, u); err != nil {
+		return fmt.Errorf("error generating docker certs: %v", err)
 	}
 	log.Printf("-- setup docker certs")
 
+	// Install client certs for the admin user
+	log.Printf("++ setup client certs")
+	if err := installClientCerts(*settings, u); err != nil {
+		return fmt.Errorf("error installing client certs: %v", err)
+	}
+	log.Printf("-- setup client certs")
+
+	// Install CA certs of private registries
+	log.Printf("++ setup registry certs")
+	if err := installRegistryCAs(settings.RegistryCAs, filepath.Join(dockerCfgDir, dockerRegistryCertsDir)); err != nil {
+		return fmt.Errorf("error installing registry certs: %v", err)
+	}
+	log.Printf("-- setup registry certs")
+
 	// Update dockeropts
 	log.Printf("++ update dockeropts")
-	restartNeeded, err := updateDockerOpts(d, getArgs(*settings, d))
+	args, daemonCfg, err := getArgs(*settings, d)
+	if err != nil {
+		return fmt.Errorf("failed to build docker daemon configuration: %v", err)
+	}
+	daemonCfgPath := filepath.Join(dockerCfgDir, dockerDaemonConfig)
+	policyCfg := daemonCfg
+	if !compat {
+		// keys in daemon.json not managed by the extension apply as well
+		if policyCfg, err = effectiveDaemonConfig(daemonCfgPath, daemonConfigState, daemonCfg, nil); err != nil {
+			return fmt.Errorf("failed to build docker daemon configuration: %v", err)
+		}
+	}
+	if err := checkPolicy(*settings, args, policyCfg); err != nil {
+		return err
+	}
+	var (
+		cfgChanged, mountsChanged bool
+		migration                 *dataRootMigration
+	)
+	if compat {
+		defaults := defaultDaemonConfig()
+		if !hasAnyKey(daemonCfg, defaults.Keys()) {
+			daemonCfg = dockeropts.MergeDaemonConfig(daemonCfg, defaults, nil)
+		}
+		if cfgChanged, err = ce.UpdateEngineConfig(daemonCfg); err != nil {
+			return fmt.Errorf("failed to update %s configuration: %v", ce.EngineName(), err)
+		}
+	} else {
+		effective, err := effectiveDaemonConfig(daemonCfgPath, daemonConfigState, daemonCfg, strings.Fields(args))
+		if err != nil {
+			return fmt.Errorf("failed to build docker daemon configuration: %v", err)
+		}
+		// also set in daemon.json or options without the userns-remap setting
+		remap, _ := effective["userns-remap"].(string)
+		if err := setupUsernsRemap(remap, daemonCfgPath); err != nil {
+			return fmt.Errorf("failed to set up userns-remap: %v", err)
+		}
+		dataRoot := effectiveDataRoot(effective)
+		if migration, err = migrateDataRoot(d, daemonCfgPath, settings.Docker.DataRoot, dataRoot); err != nil {
+			return fmt.Errorf("failed to relocate docker data-root: %v", err)
+		}
+		var mounts []string
+		if dataRoot != defaultDataRoot {
+			mounts = append(mounts, dataRoot)
+		}
+		if mountsChanged, err = d.UpdateDockerMounts(mounts); err != nil {
+			return fmt.Errorf("failed to update docker mount dependencies: %v", err)
+		}
+		if cfgChanged, err = updateDaemonConfig(daemonCfgPath, daemonConfigState, daemonCfg, defaultDaemonConfig(), strings.Fields(args)); err != nil {
+			return fmt.Errorf("failed to update docker daemon configuration: %v", err)
+		}
+	}
+	optsChanged, err := updateDockerOpts(d, args)
 	if err != nil {
 		return fmt.Errorf("failed to update dockeropts: %v", err)
 	}
+	socketGroup, socketMode, err := unixSocketConfig(settings.Docker.UnixSocket)
+	if err != nil {
+		return err
+	}
+	if !d.SocketActivated() {
+		// the group is configured in daemon.json by getArgs
+		socketGroup, socketMode = "", 0
+	}
+	socketChanged, err := d.UpdateDockerSocket(socketGroup, socketMode)
+	if err != nil {
+		return fmt.Errorf("failed to update docker socket configuration: %v", err)
+	}
+	proxyChanged, err := d.UpdateDockerProxy(proxy)
+	if err != nil {
+		return fmt.Errorf("failed to update docker proxy configuration: %v", err)
+	}
+	restartNeeded := cfgChanged || optsChanged || proxyChanged || mountsChanged || socketChanged || migration.restartNeeded()
 	log.Printf("restart needed: %v", restartNeeded)
 	log.Printf("-- update dockeropts")
 
@@ -134,16 +275,32 @@ func enable(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 	log.Printf("++ restart docker")
 	if !restartNeeded {
 		log.Printf("no restart needed. issuing only a start command.")
+		since := time.Now()
 		_ = d.StartDocker() // ignore error as it already may be running due to multiple calls to enable
+		if err := waitForDocker(); err != nil {
+			return fmt.Errorf("%v. Daemon logs:\n%s", err, daemonLogExcerpt(d, since))
+		}
 	} else {
 		log.Printf("restarting docker-engine")
-		if err := d.RestartDocker(); err != nil {
+		if err := restartDocker(d); err != nil {
 			return err
 		}
 	}
-	time.Sleep(3 * time.Second) // wait for instance to come up
+	if err := migration.finish(); err != nil {
+		return err
+	}
 	log.Printf("-- restart docker")
 
+	// Initialize, join or leave swarm
+	log.Printf("++ update swarm")
+	// rejected for other engines by checkCompatEngineSettings
+	if !compat {
+		if err := updateSwarm(*settings); err != nil {
+			return err
+		}
+	}
+	log.Printf("-- update swarm")
+
 	// Login Docker registry server
 	log.Printf("++ login docker registry")
 	if err := loginRegistry(settings.Login); err != nil {
@@ -151,6 +308,29 @@ func enable(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 	}
 	log.Printf("-- login docker registry")
 
+	// Install plugins used by the containers
+	log.Printf("++ update plugins")
+	// rejected for other engines by checkCompatEngineSettings
+	if !compat {
+		if err := updatePlugins(settings.Plugins); err != nil {
+			return err
+		}
+	}
+	log.Printf("-- update plugins")
+
+	log.Printf("++ update prune schedule")
+	if err := updatePruneSchedule(d, settings.Prune); err != nil {
+		return err
+	}
+	log.Printf("-- update prune schedule")
+
+	// Pre-pull the images so that compose up does not time out
+	log.Printf("++ prepare images")
+	if err := prepareImages(settings.Images); err != nil {
+		return err
+	}
+	log.Printf("-- prepare images")
+
 	// Compose Up
 	log.Printf("++ compose up")
 	if err := composeUp(d, settings.ComposeJson, settings.ComposeEnv, settings.ComposeProtectedEnv); err != nil {
@@ -160,9 +340,110 @@ func enable(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 	return nil
 }
 
-// installCompose download docker-compose from given url and saves to the specified path if it
+// setupProxy configures the HTTP client of the handler and the environment
+// of the commands it executes (package managers, docker-compose) to use the
+// given proxy.
+func setupProxy(p util.ProxyConfig) {
+	if !p.IsSet() {
+		log.Printf("proxy not specified")
+		return
+	}
+	log.Printf("Using proxy http=%q https=%q no-proxy=%q", redactURL(p.HTTP), redactURL(p.HTTPS), p.NoProxy)
+	util.HTTPClient = &http.Client{Transport: &http.Transport{Proxy: p.Proxy}}
+	for k, v := range p.Env() {
+		os.Setenv(k, v)
+	}
+}
+
+// redactURL removes the credentials from the given url for logging.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = url.User("xxx")
+	return u.String()
+}
+
+// withInstallRetries calls the given installation function, retrying it upon
+// failure.
+func withInstallRetries(f func() error) error {
+	// TODO(ahmetb) Temporary retry logic around installation for serialization
+	// problem in Azure VM Scale Sets. In case of scale-up event, the new VM with
+	// multiple extensions (such as Linux Diagnostics and Docker Extension) will install
+	// the extensions in parallel and that will result in non-deterministic
+	// acquisition of dpkg lock (apt-get install) and thus causing one of the
+	// extensions to fail.
+	//
+	// Adding this temporary retry logic just for Linux Diagnostics extension
+	// assuming it will take at most 5 minutes to be done with apt-get lock.
+	//
+	// This retry logic should be removed once the issue is fixed on the resource
+	// provider layer.
+
+	var (
+		nRetries      = 6
+		retryInterval = time.Minute * 1
+	)
+
+	for nRetries > 0 {
+		if err := f(); err != nil {
+			nRetries--
+			if nRetries == 0 {
+				return err
+			}
+			log.Printf("install failed. remaining attempts=%d. error=%v", nRetries, err)
+			log.Printf("sleeping %s", retryInterval)
+			time.Sleep(retryInterval)
+		} else {
+			break
+		}
+	}
+	return nil
+}
+
+// loadBundle loads and verifies the offline installation bundle at dir. If dir
+// is not specified, the bundle shipped with the extension is used.
+func loadBundle(dir string) (*pkgbundle.Bundle, error) {
+	if dir == "" {
+		sd, err := util.ScriptDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(sd, "..", offlineBundleDir)
+	}
+	log.Printf("Using offline installation bundle at %s", dir)
+	return pkgbundle.Load(dir)
+}
+
+// installDockerVersion installs the specified version of docker engine if the
+// installed version is different, and pins it. If no version is specified,
+// the version is unpinned. In offline mode, the version is only verified as
+// the installed version is determined by the offline installation bundle.
+func installDockerVersion(d driver.DistroDriver, src driver.PackageSource, version string, offline bool) error {
+	if version == "" {
+		log.Printf("docker version is not specified, unpinning")
+		return d.UnpinDockerVersion()
+	}
+	installed, err := d.DockerVersion()
+	if err != nil {
+		return err
+	}
+	if driver.VersionMatches(installed, version) {
+		log.Printf("docker version %s is already installed (%s)", version, installed)
+		return nil
+	}
+	if offline {
+		return fmt.Errorf("installed docker version %q does not match %s, offline installation bundle should provide that version", installed, version)
+	}
+	log.Printf("installed docker version is %q, installing %s", installed, version)
+	return withInstallRetries(func() error { return d.InstallDockerVersion(src, version) })
+}
+
+// installCompose downloads docker-compose from given url (or copies from the
+// offline installation bundle, if given) and saves to the specified path if it
 // is not already installed.
-func installCompose(path string, url string) error {
+func installCompose(path string, url string, bundle *pkgbundle.Bundle) error {
 	// Check if already installed at path.
 	if ok, err := util.PathExists(path); err != nil {
 		return err
@@ -182,15 +463,28 @@ func installCompose(path string, url string) error {
 		}
 	}
 
-	log.Printf("Downloading compose from %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("error downloading docker-compose: %v", err)
-	}
-	if resp.StatusCode/100 != 2 {
-		return fmt.Errorf("response status code from %s: %s", url, resp.Status)
+	var r io.ReadCloser
+	if bundle != nil {
+		src, ok := bundle.Path(composeBin)
+		if !ok {
+			return fmt.Errorf("%s is not in the offline installation bundle", composeBin)
+		}
+		log.Printf("Copying compose from %s", src)
+		if r, err = os.Open(src); err != nil {
+			return fmt.Errorf("error opening %s: %v", src, err)
+		}
+	} else {
+		log.Printf("Downloading compose from %s", url)
+		resp, err := util.HTTPClient.Get(url)
+		if err != nil {
+			return fmt.Errorf("error downloading docker-compose: %v", err)
+		}
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("response status code from %s: %s", url, resp.Status)
+		}
+		r = resp.Body
 	}
-	defer resp.Body.Close()
+	defer r.Close()
 
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0777)
 	if err != nil {
@@ -198,8 +492,8 @@ func installCompose(path string, url string) error {
 	}
 
 	defer f.Close()
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return fmt.Errorf("failed to save response body to %s: %v", path, err)
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to save docker-compose to %s: %v", path, err)
 	}
 	return nil
 }
@@ -213,10 +507,13 @@ func loginRegistry(s dockerLoginSettings) error {
 	}
 	opts := []string{
 		"login",
-		"--email=" + s.Email,
 		"--username=" + s.Username,
 		"--password=" + s.Password,
 	}
+	// --email is rejected by newer docker versions and podman
+	if s.Email != "" {
+		log.Printf("registry login email is no longer supported, ignoring")
+	}
 	if s.Server != "" {
 		opts = append(opts, s.Server)
 	}
@@ -233,6 +530,15 @@ func composeBinPath(d driver.DistroDriver) string {
 	return filepath.Join(d.DockerComposeDir(), composeBin)
 }
 
+// composeYaml converts the compose configuration in json to yaml.
+func composeYaml(json map[string]interface{}) (string, error) {
+	b, err := yaml.Marshal(json)
+	if err != nil {
+		return "", fmt.Errorf("error converting to compose.yml: %v", err)
+	}
+	return string(b), nil
+}
+
 // composeUp converts given json to yaml, saves to a file on the host and
 // uses `docker-compose up -d` to create the containers.
 func composeUp(d driver.DistroDriver, json map[string]interface{}, publicEnv, protectedEnv map[string]string) error {
@@ -241,18 +547,17 @@ func composeUp(d driver.DistroDriver, json map[string]interface{}, publicEnv, pr
 		return nil
 	}
 
-	// Convert json to yaml
-	yaml, err := yaml.Marshal(json)
+	yaml, err := composeYaml(json)
 	if err != nil {
-		return fmt.Errorf("error converting to compose.yml: %v", err)
+		return err
 	}
 
 	if err := os.MkdirAll(composeYmlDir, 0777); err != nil {
 		return fmt.Errorf("failed creating %s: %v", composeYmlDir, err)
 	}
-	log.Printf("Using compose yaml:>>>>>\n%s\n<<<<<", string(yaml))
+	log.Printf("Using compose yaml:>>>>>\n%s\n<<<<<", yaml)
 	ymlPath := filepath.Join(composeYmlDir, composeYml)
-	if err := ioutil.WriteFile(ymlPath, yaml, 0666); err != nil {
+	if err := ioutil.WriteFile(ymlPath, []byte(yaml), 0666); err != nil {
 		return fmt.Errorf("error writing %s: %v", ymlPath, err)
 	}
 
@@ -311,6 +616,20 @@ func installDockerCerts(s DockerHandlerSettings, dstDir string) error {
 		}
 	}
 
+	// Validate the certs before installing them, so that the engine does not
+	// fail to start with them
+	certs := make([][]byte, len(m))
+	for i, v := range m {
+		certs[i] = decodeSetting(v.src)
+	}
+	w, err := tlsutil.Validate(certs[0], certs[1], certs[2], tlsutil.HostNames(), time.Now())
+	if err != nil {
+		return err
+	}
+	for _, msg := range w {
+		addWarning("docker certs: %s", msg)
+	}
+
 	// Check the target directory, if not create
 	if ok, err := util.PathExists(dstDir); err != nil {
 		return fmt.Errorf("error checking cert dir: %v", err)
@@ -321,15 +640,11 @@ func installDockerCerts(s DockerHandlerSettings, dstDir string) error {
 	}
 
 	// Write the certs
-	for _, v := range m {
-		// Decode base64
-		in := strings.TrimSpace(v.src)
-		f, err := base64.StdEncoding.DecodeString(in)
-		if err != nil {
-			// Fallback to original file input
-			f = []byte(in)
+	for i, v := range m {
+		f := certs[i]
+		if err := backup.Save(v.dst); err != nil {
+			return err
 		}
-
 		if err := ioutil.WriteFile(v.dst, f, 0600); err != nil {
 			return fmt.Errorf("error writing certificate: %v", err)
 		}
@@ -337,6 +652,18 @@ func installDockerCerts(s DockerHandlerSettings, dstDir string) error {
 	return nil
 }
 
+// decodeSetting decodes the given base64 encoded setting value. If the value
+// is not base64 encoded, it is returned as is.
+func decodeSetting(s string) []byte {
+	in := strings.TrimSpace(s)
+	b, err := base64.StdEncoding.DecodeString(in)
+	if err != nil {
+		// Fallback to original file input
+		return []byte(in)
+	}
+	return b
+}
+
 func updateDockerOpts(dd driver.DistroDriver, args string) (bool, error) {
 	log.Printf("Updating daemon args to: %s", args)
 	restartNeeded, err := dd.UpdateDockerArgs(args)
@@ -346,27 +673,200 @@ func updateDockerOpts(dd driver.DistroDriver, args string) (bool, error) {
 	return restartNeeded, nil
 }
 
-// getArgs provides set of arguments that should be used in updating Docker
-// daemon options based on the distro.
-func getArgs(s DockerHandlerSettings, dd driver.DistroDriver) string {
-	args := dd.BaseOpts()
+// restartDocker restarts the docker engine to pick up the new configuration and
+// verifies that it is healthy. If not, the configuration files modified by the
+// running process are rolled back and the engine is restarted with the previous
+// configuration. The returned error contains the daemon logs explaining why the
+// new configuration is rejected.
+func restartDocker(d driver.DistroDriver) error {
+	since := time.Now()
+	err := d.RestartDocker()
+	if err == nil {
+		err = waitForDocker()
+	}
+	if err == nil {
+		return nil
+	}
+
+	logs := daemonLogExcerpt(d, since)
+	log.Printf("docker-engine failed with the new configuration: %v. Rolling back the configuration.", err)
+	paths, rerr := backup.Rollback()
+	if rerr != nil {
+		return fmt.Errorf("docker-engine failed with the new configuration (%v) and rollback failed: %v. Daemon logs:\n%s", err, rerr, logs)
+	}
+	rerr = d.RestartDockerUnits(paths)
+	if rerr == nil {
+		rerr = waitForDocker()
+	}
+	if rerr != nil {
+		log.Printf("WARNING: docker-engine failed with the previous configuration as well: %v", rerr)
+	}
+	return fmt.Errorf("docker-engine failed to start with the new configuration and the previous configuration is restored: %v. Daemon logs:\n%s", err, logs)
+}
 
-	if s.Certs.HasDockerCerts() {
-		tls := []string{"--tlsverify",
-			fmt.Sprintf("--tlscacert=%s", filepath.Join(dockerCfgDir, dockerCaCert)),
-			fmt.Sprintf("--tlscert=%s", filepath.Join(dockerCfgDir, dockerSrvCert)),
-			fmt.Sprintf("--tlskey=%s", filepath.Join(dockerCfgDir, dockerSrvKey)),
+// waitForDocker polls the docker engine until it responds or the retries are
+// exhausted.
+func waitForDocker() error {
+	var err error
+	for i := 0; i < dockerHealthCheckRetries; i++ {
+		if _, err = executil.Exec("docker", "info"); err == nil {
+			return nil
 		}
-		args = append(args, tls...)
+		time.Sleep(dockerHealthCheckInterval)
 	}
+	return fmt.Errorf("docker-engine is not responding: %v", err)
+}
 
-	if s.Docker.Port != "" {
-		args = append(args, fmt.Sprintf("-H=0.0.0.0:%s", s.Docker.Port))
+// daemonLogExcerpt returns the error lines in the daemon logs since the given
+// time, or the last lines of the logs if there are no error lines.
+func daemonLogExcerpt(d driver.DistroDriver, since time.Time) string {
+	out, err := d.DaemonLogs(since)
+	if err != nil {
+		log.Printf("WARNING: cannot read docker-engine logs: %v", err)
+	}
+	var lines, errLines []string
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if l == "" {
+			continue
+		}
+		lines = append(lines, l)
+		if ll := strings.ToLower(l); strings.Contains(ll, "level=error") ||
+			strings.Contains(ll, "level=fatal") || strings.Contains(ll, "failed") {
+			errLines = append(errLines, l)
+		}
+	}
+	if len(errLines) > 0 {
+		lines = errLines
+	}
+	if len(lines) > daemonLogExcerptLines {
+		lines = lines[len(lines)-daemonLogExcerptLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// engineConfig validates the docker settings that are mapped into daemon.json
+// and returns the daemon configuration for them.
+func engineConfig(s dockerEngineSettings) (dockeropts.DaemonConfig, error) {
+	cfg, err := registryConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	if s.DataRoot != "" {
+		if err := validateDataRoot(s.DataRoot); err != nil {
+			return nil, err
+		}
+		cfg["data-root"] = s.DataRoot
+	}
+	if s.UsernsRemap != "" {
+		if _, _, err := parseUsernsRemap(s.UsernsRemap); err != nil {
+			return nil, err
+		}
+		cfg["userns-remap"] = s.UsernsRemap
+	}
+	if s.Logging.IsSet() {
+		opts := make(map[string]string)
+		for k, v := range s.Logging.Options {
+			opts[k] = v
+		}
+		rotation := make(map[string]string)
+		if s.Logging.MaxSize != "" {
+			rotation["max-size"] = s.Logging.MaxSize
+		}
+		if s.Logging.MaxFile != 0 {
+			rotation["max-file"] = strconv.Itoa(s.Logging.MaxFile)
+		}
+		for k, v := range rotation {
+			if _, ok := opts[k]; ok {
+				return nil, fmt.Errorf("logging %s is specified both as a setting and in logging options", k)
+			}
+			opts[k] = v
+		}
+		logCfg, err := dockeropts.LogConfig(s.Logging.Driver, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logging settings: %v", err)
+		}
+		for k, v := range logCfg {
+			cfg[k] = v
+		}
+	}
+	return cfg, nil
+}
+
+// defaultDaemonConfig returns the daemon configuration applied only if the
+// keys are not configured by the user (in the settings or daemon.json), so
+// that the container logs do not fill up the disk.
+func defaultDaemonConfig() dockeropts.DaemonConfig {
+	cfg, _ := dockeropts.LogConfig(dockeropts.DefaultLogDriver, nil)
+	return cfg
+}
+
+// getArgs provides the command line arguments and the daemon.json configuration
+// that should be used for the Docker daemon based on the distro. Listeners are
+// kept as command line arguments since the base listener depends on how the
+// init system of the distro starts the daemon; TLS settings and the options that
+// have a daemon.json counterpart are mapped into the daemon configuration.
+func getArgs(s DockerHandlerSettings, dd driver.DistroDriver) (string, dockeropts.DaemonConfig, error) {
+	args := dd.BaseOpts()
+
+	cfg := dockeropts.DaemonConfig{}
+	if s.hasTLS() {
+		cfg["tlsverify"] = true
+		cfg["tlscacert"] = filepath.Join(dockerCfgDir, dockerCaCert)
+		cfg["tlscert"] = filepath.Join(dockerCfgDir, dockerSrvCert)
+		cfg["tlskey"] = filepath.Join(dockerCfgDir, dockerSrvKey)
+	}
+
+	engineCfg, err := engineConfig(s.Docker)
+	if err != nil {
+		return "", nil, err
+	}
+	if !dd.SocketActivated() {
+		// the daemon creates the unix socket itself with the group in
+		// daemon.json and mode 0660
+		group, mode, err := unixSocketConfig(s.Docker.UnixSocket)
+		if err != nil {
+			return "", nil, err
+		}
+		if mode != 0 && mode != 0660 {
+			return "", nil, fmt.Errorf("unix socket mode %04o is not supported without socket activation, the daemon creates the socket with mode 0660", mode)
+		}
+		if group != "" {
+			engineCfg["group"] = group
+		}
+	}
+	for k, v := range engineCfg {
+		cfg[k] = v
 	}
 
-	if len(s.Docker.Options) > 0 {
-		args = append(args, s.Docker.Options...)
+	opts, rest, err := dockeropts.ParseFlags(dockeropts.SplitFlags(s.Docker.Options))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid docker options: %v", err)
+	}
+	for k := range engineCfg {
+		if _, ok := opts[k]; ok {
+			return "", nil, fmt.Errorf("daemon option %q is specified both in docker options and docker settings", k)
+		}
+		if _, ok := s.Docker.DaemonConfig[k]; ok {
+			return "", nil, fmt.Errorf("daemon option %q is specified both in daemon-config and docker settings", k)
+		}
+	}
+	optHosts, _ := opts["hosts"].([]string)
+	delete(opts, "hosts")
+	listeners, err := listenerArgs(s.Docker, optHosts)
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, listeners...)
+	args = append(args, rest...)
+	for k, v := range opts {
+		cfg[k] = v
 	}
 
-	return strings.Join(args, " ")
+	for k, v := range s.Docker.DaemonConfig {
+		if _, ok := opts[k]; ok {
+			return "", nil, fmt.Errorf("daemon option %q is specified both in docker options and daemon-config", k)
+		}
+		cfg[k] = v
+	}
+	return strings.Join(args, " "), cfg, nil
 }
@@ -1,13 +1,34 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/driver"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
 	"github.com/Azure/azure-docker-extension/pkg/vmextension"
 )
 
 func uninstall(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
+	log.Println("++ remove rootless docker")
+	if err := teardownRootless(d, ""); err != nil {
+		return err
+	}
+	log.Println("-- remove rootless docker")
+
+	log.Println("++ remove prune schedule")
+	if err := updatePruneSchedule(d, pruneSettings{}); err != nil {
+		return err
+	}
+	log.Println("-- remove prune schedule")
+
+	log.Println("++ restore docker configuration")
+	if err := restoreDockerConfig(d); err != nil {
+		return err
+	}
+	log.Println("-- restore docker configuration")
+
 	log.Println("++ uninstall docker")
 	if err := d.UninstallDocker(); err != nil {
 		return err
@@ -25,3 +46,34 @@ func uninstall(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
 func uninstallDockerCompose(d driver.DistroDriver) error {
 	return os.RemoveAll(composeBinPath(d))
 }
+
+// restoreDockerConfig restores the files modified by the extension to their
+// state before the extension has modified them, and reloads the units
+// configured by them.
+func restoreDockerConfig(d driver.DistroDriver) error {
+	l, err := backup.Entries()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, e := range l {
+		paths = append(paths, e.Path)
+		if e.Existed {
+			log.Printf("Restoring %s (backed up at %s)", e.Path, e.Time)
+		} else {
+			log.Printf("Removing %s", e.Path)
+		}
+	}
+	if err := backup.Restore(); err != nil {
+		return err
+	}
+	// e.g. the systemd drop-ins are removed
+	if err := d.ReloadDockerUnits(paths); err != nil {
+		return fmt.Errorf("error reloading docker units: %v", err)
+	}
+	// the daemon.json keys are no longer managed by the extension
+	if err := statefile.Delete(rootlessDaemonConfigState); err != nil {
+		return err
+	}
+	return statefile.Delete(daemonConfigState)
+}
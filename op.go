@@ -11,12 +11,14 @@ type Op struct {
 	f             OperationFunc
 	name          string
 	reportsStatus bool // determines if op should log to .status file
+	scheduled     bool // run on a schedule rather than by the agent, does not use .seqnum file
 }
 
 var operations = map[string]Op{
-	"install":   Op{install, "Install Docker", false},
-	"uninstall": Op{uninstall, "Uninstall Docker", false},
-	"enable":    Op{enable, "Enable Docker", true},
-	"update":    Op{update, "Updating Docker", true},
-	"disable":   Op{disable, "Disabling Docker", true},
+	"install":   Op{install, "Install Docker", false, false},
+	"uninstall": Op{uninstall, "Uninstall Docker", false, false},
+	"enable":    Op{enable, "Enable Docker", true, false},
+	"update":    Op{update, "Updating Docker", true, false},
+	"disable":   Op{disable, "Disabling Docker", true, false},
+	"prune":     Op{prune, "Pruning Docker", false, true},
 }
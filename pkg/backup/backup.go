@@ -0,0 +1,220 @@
+// Package backup keeps a copy of the host files before they are modified
+// by the extension for the first time, so that the host configuration can be
+// restored to its state before the extension was installed.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+// indexState is the name of the state file that keeps the metadata of the
+// backed up files.
+const indexState = "backup-index"
+
+// Entry is the metadata of a backed up file.
+type Entry struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"` // false if the file did not exist before
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Uid     int         `json:"uid,omitempty"`
+	Gid     int         `json:"gid,omitempty"`
+	Copy    string      `json:"copy,omitempty"` // file name of the copy in backup dir
+	SHA256  string      `json:"sha256,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+// journalEntry is the contents of a file before it is modified by the
+// running process.
+type journalEntry struct {
+	path     string
+	existed  bool
+	mode     os.FileMode
+	uid, gid int
+	b        []byte
+}
+
+// journal keeps the contents of the files before they are modified by the
+// running process, so that the changes can be rolled back if the new
+// configuration does not work.
+var journal []journalEntry
+
+// Dir returns the directory the copies of the files are saved in.
+func Dir() string {
+	return filepath.Join(statefile.Dir, "backup")
+}
+
+// Entries returns the metadata of the backed up files in the order they are
+// saved.
+func Entries() ([]Entry, error) {
+	var l []Entry
+	if _, err := statefile.Get(indexState, &l); err != nil {
+		return nil, fmt.Errorf("backup: cannot read index: %v", err)
+	}
+	return l, nil
+}
+
+// Save backs up the file at path if it is not backed up already. If the file
+// does not exist, this is recorded so that it is deleted upon restore. Save
+// also records the current contents of the file for Rollback.
+func Save(path string) error {
+	if err := record(path); err != nil {
+		return err
+	}
+
+	l, err := Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range l {
+		if e.Path == path {
+			return nil
+		}
+	}
+
+	e := Entry{Path: path, Time: time.Now().UTC()}
+	fi, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("backup: cannot stat %s: %v", path, err)
+	} else if err == nil {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("backup: cannot read %s: %v", path, err)
+		}
+		sum := sha256.Sum256(b)
+		e.Existed = true
+		e.Mode = fi.Mode().Perm()
+		e.SHA256 = hex.EncodeToString(sum[:])
+		e.Copy = fmt.Sprintf("%d-%s", len(l), filepath.Base(path))
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			e.Uid, e.Gid = int(st.Uid), int(st.Gid)
+		}
+
+		if err := os.MkdirAll(Dir(), 0700); err != nil {
+			return fmt.Errorf("backup: cannot create %s: %v", Dir(), err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(Dir(), e.Copy), b, 0600); err != nil {
+			return fmt.Errorf("backup: cannot save copy of %s: %v", path, err)
+		}
+	}
+
+	if err := statefile.Set(indexState, append(l, e)); err != nil {
+		return fmt.Errorf("backup: cannot save index: %v", err)
+	}
+	return nil
+}
+
+// record adds the current contents of the file to the journal if it is not
+// recorded already.
+func record(path string) error {
+	for _, j := range journal {
+		if j.path == path {
+			return nil
+		}
+	}
+	j := journalEntry{path: path}
+	fi, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("backup: cannot stat %s: %v", path, err)
+	} else if err == nil {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("backup: cannot read %s: %v", path, err)
+		}
+		j.existed, j.mode, j.b = true, fi.Mode().Perm(), b
+		j.uid, j.gid = os.Getuid(), os.Getgid()
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			j.uid, j.gid = int(st.Uid), int(st.Gid)
+		}
+	}
+	journal = append(journal, j)
+	return nil
+}
+
+// Rollback reverts the files saved by the running process to their contents,
+// mode and owner at the time they were first saved by the running process.
+// It returns the paths of the reverted files, so that the services using
+// them can be restarted.
+func Rollback() ([]string, error) {
+	var paths []string
+	for i := len(journal) - 1; i >= 0; i-- {
+		j := journal[i]
+		paths = append(paths, j.path)
+		if !j.existed {
+			if err := os.RemoveAll(j.path); err != nil {
+				return paths, fmt.Errorf("backup: cannot remove %s: %v", j.path, err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(j.path, j.b, j.mode); err != nil {
+			return paths, fmt.Errorf("backup: cannot roll back %s: %v", j.path, err)
+		}
+		// WriteFile does not change the mode of existing files
+		if err := os.Chmod(j.path, j.mode); err != nil {
+			return paths, fmt.Errorf("backup: cannot chmod %s: %v", j.path, err)
+		}
+		if err := os.Lchown(j.path, j.uid, j.gid); err != nil {
+			return paths, fmt.Errorf("backup: cannot chown %s: %v", j.path, err)
+		}
+	}
+	journal = nil
+	return paths, nil
+}
+
+// Restore puts the backed up files back to their original paths (and deletes
+// the files that did not exist before) in the reverse order they are saved,
+// then removes the backups.
+func Restore() error {
+	l, err := Entries()
+	if err != nil {
+		return err
+	}
+	for i := len(l) - 1; i >= 0; i-- {
+		if err := restore(l[i]); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(Dir()); err != nil {
+		return fmt.Errorf("backup: cannot remove %s: %v", Dir(), err)
+	}
+	return statefile.Delete(indexState)
+}
+
+func restore(e Entry) error {
+	if !e.Existed {
+		if err := os.RemoveAll(e.Path); err != nil {
+			return fmt.Errorf("backup: cannot remove %s: %v", e.Path, err)
+		}
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(Dir(), e.Copy))
+	if err != nil {
+		return fmt.Errorf("backup: cannot read copy of %s: %v", e.Path, err)
+	}
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != e.SHA256 {
+		return fmt.Errorf("backup: copy of %s is corrupted (checksum mismatch)", e.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+		return fmt.Errorf("backup: cannot create %s: %v", filepath.Dir(e.Path), err)
+	}
+	if err := ioutil.WriteFile(e.Path, b, e.Mode); err != nil {
+		return fmt.Errorf("backup: cannot restore %s: %v", e.Path, err)
+	}
+	// WriteFile does not change the mode of existing files
+	if err := os.Chmod(e.Path, e.Mode); err != nil {
+		return fmt.Errorf("backup: cannot chmod %s: %v", e.Path, err)
+	}
+	if err := os.Lchown(e.Path, e.Uid, e.Gid); err != nil {
+		return fmt.Errorf("backup: cannot chown %s: %v", e.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_SaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statefile.Dir = filepath.Join(dir, "state")
+
+	existing := filepath.Join(dir, "existing.conf")
+	created := filepath.Join(dir, "sub", "created.conf")
+	if err := ioutil.WriteFile(existing, []byte("original"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{existing, created} {
+		if err := Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// modify files and save again, which should not overwrite the backups
+	if err := ioutil.WriteFile(existing, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(created), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(created, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(existing); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Entries(); err != nil {
+		t.Fatal(err)
+	} else if len(l) != 2 {
+		t.Fatalf("expected 2 entries, got: %d", len(l))
+	}
+
+	if err := Restore(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "original" {
+		t.Fatalf("got wrong contents after restore: %q", b)
+	}
+	if fi, err := os.Stat(existing); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Fatalf("got wrong mode after restore: %v", fi.Mode())
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("%s should be deleted, got: %v", created, err)
+	}
+	if l, err := Entries(); err != nil {
+		t.Fatal(err)
+	} else if len(l) != 0 {
+		t.Fatalf("backups should be cleared, got: %v", l)
+	}
+}
+
+func Test_Rollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statefile.Dir = filepath.Join(dir, "state")
+	journal = nil
+
+	existing := filepath.Join(dir, "existing.conf")
+	created := filepath.Join(dir, "created.conf")
+	if err := ioutil.WriteFile(existing, []byte("previous"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{existing, created} {
+		if err := Save(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(existing, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{created, existing}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("got wrong rolled back paths: %v, expected: %v", paths, expected)
+	}
+	if b, err := ioutil.ReadFile(existing); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "previous" {
+		t.Fatalf("got wrong contents after rollback: %q", b)
+	}
+	if fi, err := os.Stat(existing); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Fatalf("got wrong mode after rollback: %v", fi.Mode())
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("%s should be deleted, got: %v", created, err)
+	}
+}
@@ -18,7 +18,10 @@ const (
 	CentosID = "CentOS"
 )
 
-type Info struct{ Id, Release string }
+type Info struct {
+	Id, Release string
+	Codename    string // only available from LSB info
+}
 
 func (d Info) String() string {
 	return fmt.Sprintf("%s %s", d.Id, d.Release)
@@ -62,6 +65,7 @@ func (l lsbReleaseInfo) parse(b []byte) (Info, error) {
 		}
 		*f.val = v
 	}
+	d.Codename = m["DISTRIB_CODENAME"]
 	return d, nil
 }
 
@@ -34,6 +34,9 @@ DISTRIB_DESCRIPTION="Ubuntu 14.04.3 LTS"`)
 	if d.Release != "14.04" {
 		t.Fatalf("wrong disro release: %s", d.Release)
 	}
+	if d.Codename != "trusty" {
+		t.Fatalf("wrong disro codename: %s", d.Codename)
+	}
 }
 
 func Test_centosReleaseInfo_parseVersion(t *testing.T) {
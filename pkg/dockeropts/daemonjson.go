@@ -0,0 +1,287 @@
+package dockeropts
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DaemonConfig represents the contents of the docker daemon configuration
+// file (daemon.json) as a map of configuration keys to values.
+type DaemonConfig map[string]interface{}
+
+type flagKind int
+
+const (
+	boolFlag flagKind = iota
+	stringFlag
+	intFlag
+	listFlag
+	mapFlag
+)
+
+// daemonFlag describes how a dockerd command line flag is represented in
+// daemon.json.
+type daemonFlag struct {
+	key  string
+	kind flagKind
+}
+
+// daemonFlags maps long names of dockerd flags to daemon.json keys.
+var daemonFlags = map[string]daemonFlag{
+	"debug":                    {"debug", boolFlag},
+	"experimental":             {"experimental", boolFlag},
+	"icc":                      {"icc", boolFlag},
+	"init":                     {"init", boolFlag},
+	"ip-forward":               {"ip-forward", boolFlag},
+	"ip-masq":                  {"ip-masq", boolFlag},
+	"iptables":                 {"iptables", boolFlag},
+	"ipv6":                     {"ipv6", boolFlag},
+	"live-restore":             {"live-restore", boolFlag},
+	"no-new-privileges":        {"no-new-privileges", boolFlag},
+	"raw-logs":                 {"raw-logs", boolFlag},
+	"selinux-enabled":          {"selinux-enabled", boolFlag},
+	"tls":                      {"tls", boolFlag},
+	"tlsverify":                {"tlsverify", boolFlag},
+	"userland-proxy":           {"userland-proxy", boolFlag},
+	"bip":                      {"bip", stringFlag},
+	"bridge":                   {"bridge", stringFlag},
+	"cgroup-parent":            {"cgroup-parent", stringFlag},
+	"containerd":               {"containerd", stringFlag},
+	"data-root":                {"data-root", stringFlag},
+	"default-gateway":          {"default-gateway", stringFlag},
+	"default-runtime":          {"default-runtime", stringFlag},
+	"exec-root":                {"exec-root", stringFlag},
+	"fixed-cidr":               {"fixed-cidr", stringFlag},
+	"fixed-cidr-v6":            {"fixed-cidr-v6", stringFlag},
+	"graph":                    {"graph", stringFlag},
+	"group":                    {"group", stringFlag},
+	"ip":                       {"ip", stringFlag},
+	"log-driver":               {"log-driver", stringFlag},
+	"log-level":                {"log-level", stringFlag},
+	"pidfile":                  {"pidfile", stringFlag},
+	"seccomp-profile":          {"seccomp-profile", stringFlag},
+	"storage-driver":           {"storage-driver", stringFlag},
+	"tlscacert":                {"tlscacert", stringFlag},
+	"tlscert":                  {"tlscert", stringFlag},
+	"tlskey":                   {"tlskey", stringFlag},
+	"userns-remap":             {"userns-remap", stringFlag},
+	"max-concurrent-downloads": {"max-concurrent-downloads", intFlag},
+	"max-concurrent-uploads":   {"max-concurrent-uploads", intFlag},
+	"max-download-attempts":    {"max-download-attempts", intFlag},
+	"mtu":                      {"mtu", intFlag},
+	"shutdown-timeout":         {"shutdown-timeout", intFlag},
+	"authorization-plugin":     {"authorization-plugins", listFlag},
+	"dns":                      {"dns", listFlag},
+	"dns-opt":                  {"dns-opts", listFlag},
+	"dns-search":               {"dns-search", listFlag},
+	"exec-opt":                 {"exec-opts", listFlag},
+	"host":                     {"hosts", listFlag},
+	"insecure-registry":        {"insecure-registries", listFlag},
+	"label":                    {"labels", listFlag},
+	"registry-mirror":          {"registry-mirrors", listFlag},
+	"storage-opt":              {"storage-opts", listFlag},
+	"log-opt":                  {"log-opts", mapFlag},
+}
+
+// shortFlags maps single-letter dockerd flags to their long names.
+var shortFlags = map[string]string{
+	"b": "bridge",
+	"D": "debug",
+	"g": "graph",
+	"G": "group",
+	"H": "host",
+	"l": "log-level",
+	"p": "pidfile",
+	"s": "storage-driver",
+}
+
+// lookupFlag splits a command line token such as '--dns=8.8.8.8' into the
+// long flag name and the value (if given with '=') and returns how the flag
+// is represented in daemon.json.
+func lookupFlag(tok string) (name, value string, hasValue bool, f daemonFlag, ok bool) {
+	name = strings.TrimLeft(tok, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name, value, hasValue = name[:i], name[i+1:], true
+	}
+	if long, isShort := shortFlags[name]; isShort && !strings.HasPrefix(tok, "--") {
+		name = long
+	}
+	f, ok = daemonFlags[name]
+	return
+}
+
+// SplitFlags splits given option strings (each possibly containing multiple
+// whitespace-separated tokens, e.g. "--label foo=bar") into tokens.
+func SplitFlags(opts []string) []string {
+	var out []string
+	for _, o := range opts {
+		out = append(out, strings.Fields(o)...)
+	}
+	return out
+}
+
+// ParseFlags converts the given dockerd command line flags into daemon.json
+// configuration. Flags that do not have a daemon.json counterpart (and their
+// values) are returned in rest, in the order they are given.
+func ParseFlags(args []string) (cfg DaemonConfig, rest []string, err error) {
+	cfg = DaemonConfig{}
+	for i := 0; i < len(args); i++ {
+		tok := args[i]
+		if !strings.HasPrefix(tok, "-") {
+			rest = append(rest, tok)
+			continue
+		}
+		name, value, hasValue, f, ok := lookupFlag(tok)
+		if !ok {
+			rest = append(rest, tok)
+			if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+
+		if f.kind == boolFlag {
+			v := true
+			if hasValue {
+				if v, err = strconv.ParseBool(value); err != nil {
+					return nil, nil, fmt.Errorf("invalid value for --%s: %q", name, value)
+				}
+			}
+			cfg[f.key] = v
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag --%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		switch f.kind {
+		case stringFlag:
+			cfg[f.key] = value
+		case intFlag:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value for --%s: %q", name, value)
+			}
+			cfg[f.key] = n
+		case listFlag:
+			l, _ := cfg[f.key].([]string)
+			cfg[f.key] = append(l, value)
+		case mapFlag:
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 {
+				return nil, nil, fmt.Errorf("invalid value for --%s: %q, expected key=value", name, value)
+			}
+			m, _ := cfg[f.key].(map[string]string)
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[kv[0]] = kv[1]
+			cfg[f.key] = m
+		}
+	}
+	return cfg, rest, nil
+}
+
+// FlagConflicts returns the daemon.json keys that are also specified by
+// the given command line flags. dockerd refuses to start if an option is
+// specified both as a flag and in daemon.json.
+func FlagConflicts(args []string, cfg DaemonConfig) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, tok := range args {
+		if !strings.HasPrefix(tok, "-") {
+			continue
+		}
+		if _, _, _, f, ok := lookupFlag(tok); ok {
+			if _, exists := cfg[f.key]; exists && !seen[f.key] {
+				seen[f.key] = true
+				out = append(out, f.key)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseDaemonConfig parses contents of a daemon.json file. Empty contents
+// are parsed as an empty configuration.
+func ParseDaemonConfig(b []byte) (DaemonConfig, error) {
+	cfg := DaemonConfig{}
+	if strings.TrimSpace(string(b)) == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse daemon config: %v", err)
+	}
+	return cfg, nil
+}
+
+// MergeDaemonConfig returns a copy of existing configuration where the keys
+// previously managed (but no longer specified) are removed and the keys in
+// managed configuration are set. Other keys in existing configuration are
+// preserved.
+func MergeDaemonConfig(existing, managed DaemonConfig, prevManaged []string) DaemonConfig {
+	out := DaemonConfig{}
+	for k, v := range existing {
+		out[k] = v
+	}
+	for _, k := range prevManaged {
+		delete(out, k)
+	}
+	for k, v := range managed {
+		out[k] = v
+	}
+	return out
+}
+
+// Keys returns the configuration keys in sorted order.
+func (c DaemonConfig) Keys() []string {
+	out := make([]string, 0, len(c))
+	for k := range c {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Marshal serializes the configuration in daemon.json format.
+func (c DaemonConfig) Marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Equal reports whether two configurations are semantically equal, i.e.
+// they would be identical once serialized and parsed by dockerd.
+func (c DaemonConfig) Equal(o DaemonConfig) bool {
+	a, err := c.normalize()
+	if err != nil {
+		return false
+	}
+	b, err := o.normalize()
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (c DaemonConfig) normalize() (map[string]interface{}, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
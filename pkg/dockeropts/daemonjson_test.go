@@ -0,0 +1,87 @@
+package dockeropts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParseFlags(t *testing.T) {
+	cfg, rest, err := ParseFlags(SplitFlags([]string{
+		"-D",
+		"--dns=8.8.8.8",
+		"--dns 8.8.4.4",
+		"--label env=prod",
+		"--log-opt max-size=10m",
+		"--icc=false",
+		"--mtu=1400",
+		"-s overlay2",
+		"--unknown-flag foo",
+		"--other",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DaemonConfig{
+		"debug":          true,
+		"dns":            []string{"8.8.8.8", "8.8.4.4"},
+		"labels":         []string{"env=prod"},
+		"log-opts":       map[string]string{"max-size": "10m"},
+		"icc":            false,
+		"mtu":            1400,
+		"storage-driver": "overlay2",
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("got wrong config: %#v\nexpected: %#v", cfg, expected)
+	}
+	if expected := []string{"--unknown-flag", "foo", "--other"}; !reflect.DeepEqual(rest, expected) {
+		t.Fatalf("got wrong remaining flags: %v, expected: %v", rest, expected)
+	}
+}
+
+func Test_ParseFlags_Bad(t *testing.T) {
+	for _, in := range [][]string{
+		{"--dns"},
+		{"--mtu=abc"},
+		{"--icc=maybe"},
+		{"--log-opt=foo"},
+	} {
+		if _, _, err := ParseFlags(in); err == nil {
+			t.Fatalf("expected error for %v", in)
+		}
+	}
+}
+
+func Test_FlagConflicts(t *testing.T) {
+	cfg := DaemonConfig{"hosts": []string{"fd://"}, "debug": true, "labels": []string{"a=b"}}
+	out := FlagConflicts([]string{"-H=unix://", "-H=0.0.0.0:2376", "--debug", "--foo"}, cfg)
+	if expected := []string{"debug", "hosts"}; !reflect.DeepEqual(out, expected) {
+		t.Fatalf("got wrong conflicts: %v, expected: %v", out, expected)
+	}
+}
+
+func Test_MergeDaemonConfig(t *testing.T) {
+	existing, err := ParseDaemonConfig([]byte(`{"debug": true, "log-level": "warn", "mtu": 1400}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	managed := DaemonConfig{"tlsverify": true, "mtu": 1500}
+	out := MergeDaemonConfig(existing, managed, []string{"log-level"})
+
+	expected := DaemonConfig{"debug": true, "tlsverify": true, "mtu": 1500}
+	if !out.Equal(expected) {
+		t.Fatalf("got wrong config: %#v\nexpected: %#v", out, expected)
+	}
+	if out.Equal(existing) {
+		t.Fatal("merged config should be different from existing")
+	}
+}
+
+func Test_ParseDaemonConfig_Empty(t *testing.T) {
+	cfg, err := ParseDaemonConfig([]byte("\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg) != 0 {
+		t.Fatalf("expected empty config, got: %v", cfg)
+	}
+}
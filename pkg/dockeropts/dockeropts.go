@@ -1,5 +1,6 @@
 // Package dockeropts provides various methods to modify docker
-// service start arguments (a.k.a DOCKER_OPTS)
+// service start arguments (a.k.a DOCKER_OPTS) and the docker daemon
+// configuration file (daemon.json).
 package dockeropts
 
 // Editor describes an implementation that can
@@ -0,0 +1,57 @@
+package dockeropts
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultListeners are the listeners the base options of the distro drivers
+// already provide (the unix socket, passed by systemd or created by dockerd).
+var defaultListeners = map[string]bool{
+	"fd://":                       true,
+	"unix://":                     true,
+	"unix:///var/run/docker.sock": true,
+	"unix:///run/docker.sock":     true,
+}
+
+// IsDefaultListener reports whether the listener is the default unix socket
+// of the docker engine, which is configured by the base options.
+func IsDefaultListener(l string) bool {
+	return defaultListeners[l]
+}
+
+// ValidateListener returns error if the given value is not a valid listener
+// address for dockerd, such as 'tcp://10.0.0.4:2376', 'unix:///path/to.sock'
+// or 'fd://'.
+func ValidateListener(l string) error {
+	switch {
+	case strings.HasPrefix(l, "tcp://"):
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(l, "tcp://"))
+		if err != nil {
+			return fmt.Errorf("invalid listener %q: %v", l, err)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			if err := ValidateRegistryHost(host); err != nil {
+				return fmt.Errorf("invalid listener %q: invalid host", l)
+			}
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid listener %q: invalid port", l)
+		}
+	case strings.HasPrefix(l, "unix://"):
+		if p := strings.TrimPrefix(l, "unix://"); p != "" && !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("invalid listener %q: socket path must be absolute", l)
+		}
+	case strings.HasPrefix(l, "fd://"):
+	default:
+		return fmt.Errorf("invalid listener %q: expected tcp://, unix:// or fd://", l)
+	}
+	return nil
+}
+
+// IsTCPListener reports whether the listener accepts network connections.
+func IsTCPListener(l string) bool {
+	return strings.HasPrefix(l, "tcp://")
+}
@@ -0,0 +1,29 @@
+package dockeropts
+
+import (
+	"testing"
+)
+
+func Test_ValidateListener(t *testing.T) {
+	for _, c := range []struct {
+		in string
+		ok bool
+	}{
+		{"tcp://0.0.0.0:2376", true},
+		{"tcp://10.0.0.4:2376", true},
+		{"tcp://[fd00::4]:2376", true},
+		{"tcp://myvm.internal:2376", true},
+		{"unix:///var/run/docker.sock", true},
+		{"unix://", true},
+		{"fd://", true},
+		{"tcp://10.0.0.4", false},
+		{"tcp://10.0.0.4:http", false},
+		{"tcp://10.0.0.4:70000", false},
+		{"unix://docker.sock", false},
+		{"10.0.0.4:2376", false},
+	} {
+		if err := ValidateListener(c.in); (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %q, expected ok=%v", err, c.in, c.ok)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dockeropts
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// DefaultLogDriver is the logging driver of dockerd if none is configured.
+const DefaultLogDriver = "json-file"
+
+// DefaultLogRotation is the rotation applied to the logs of the drivers
+// writing to local files when no rotation is configured.
+var DefaultLogRotation = map[string]string{
+	"max-size": "10m",
+	"max-file": "3",
+}
+
+var logSizeRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?\s*([kKmMgG][bB]?|[bB])?$`)
+
+// rotatingLogDrivers are the logging drivers that write to local files and
+// support max-size and max-file options.
+var rotatingLogDrivers = map[string]bool{
+	"json-file": true,
+	"local":     true,
+}
+
+// LogConfig validates the given logging driver and its options, and returns
+// the daemon.json configuration for them. If the driver writes to local files
+// and no max-size is given, the default rotation is applied.
+func LogConfig(driver string, opts map[string]string) (DaemonConfig, error) {
+	if driver == "" {
+		driver = DefaultLogDriver
+	}
+	out := make(map[string]string)
+	for k, v := range opts {
+		out[k] = v
+	}
+
+	if rotatingLogDrivers[driver] {
+		if _, ok := out["max-size"]; !ok {
+			for k, v := range DefaultLogRotation {
+				if _, ok := out[k]; !ok {
+					out[k] = v
+				}
+			}
+		}
+	} else {
+		for _, k := range []string{"max-size", "max-file"} {
+			if _, ok := out[k]; ok {
+				return nil, fmt.Errorf("log option %q is not supported by logging driver %q", k, driver)
+			}
+		}
+	}
+	if v, ok := out["max-size"]; ok && !logSizeRegexp.MatchString(v) {
+		return nil, fmt.Errorf("invalid log option max-size %q, expected a size such as 10m", v)
+	}
+	if v, ok := out["max-file"]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid log option max-file %q, expected a positive integer", v)
+		}
+	}
+
+	cfg := DaemonConfig{"log-driver": driver}
+	if len(out) > 0 {
+		cfg["log-opts"] = out
+	}
+	return cfg, nil
+}
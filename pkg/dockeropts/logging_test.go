@@ -0,0 +1,51 @@
+package dockeropts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_LogConfig(t *testing.T) {
+	for _, c := range []struct {
+		driver string
+		opts   map[string]string
+		out    DaemonConfig
+	}{
+		{"", nil, DaemonConfig{
+			"log-driver": "json-file",
+			"log-opts":   map[string]string{"max-size": "10m", "max-file": "3"}}},
+		{"local", map[string]string{"max-size": "50m"}, DaemonConfig{
+			"log-driver": "local",
+			"log-opts":   map[string]string{"max-size": "50m"}}},
+		{"json-file", map[string]string{"max-file": "5", "compress": "true"}, DaemonConfig{
+			"log-driver": "json-file",
+			"log-opts":   map[string]string{"max-size": "10m", "max-file": "5", "compress": "true"}}},
+		{"journald", nil, DaemonConfig{"log-driver": "journald"}},
+		{"fluentd", map[string]string{"fluentd-address": "localhost:24224"}, DaemonConfig{
+			"log-driver": "fluentd",
+			"log-opts":   map[string]string{"fluentd-address": "localhost:24224"}}},
+	} {
+		out, err := LogConfig(c.driver, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, c.out) {
+			t.Fatalf("got: %#v\nexpected: %#v", out, c.out)
+		}
+	}
+}
+
+func Test_LogConfig_Invalid(t *testing.T) {
+	for _, c := range []struct {
+		driver string
+		opts   map[string]string
+	}{
+		{"syslog", map[string]string{"max-size": "10m"}},
+		{"json-file", map[string]string{"max-size": "ten"}},
+		{"json-file", map[string]string{"max-file": "0"}},
+	} {
+		if _, err := LogConfig(c.driver, c.opts); err == nil {
+			t.Fatalf("expected error for driver=%q opts=%v", c.driver, c.opts)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package dockeropts
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ValidateRegistryMirror returns error if the given value is not a valid
+// registry-mirrors entry, which is an http(s) URL without a path.
+func ValidateRegistryMirror(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid registry mirror %q: %v", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid registry mirror %q: expected http(s)://host[:port]", s)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid registry mirror %q: path is not allowed", s)
+	}
+	return nil
+}
+
+// ValidateInsecureRegistry returns error if the given value is not a valid
+// insecure-registries entry, which is a registry host[:port] or a CIDR.
+func ValidateInsecureRegistry(s string) error {
+	if strings.Contains(s, "/") {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return fmt.Errorf("invalid insecure registry %q: %v", s, err)
+		}
+		return nil
+	}
+	if err := ValidateRegistryHost(s); err != nil {
+		return fmt.Errorf("invalid insecure registry: %v", err)
+	}
+	return nil
+}
+
+// ValidateRegistryHost returns error if the given value is not a registry
+// host name with an optional port, such as 'registry.example.com:5000', in
+// the form it is used under /etc/docker/certs.d.
+func ValidateRegistryHost(s string) error {
+	host, port := s, ""
+	if i := strings.LastIndex(s, ":"); i >= 0 && !strings.HasSuffix(s, "]") {
+		host, port = s[:i], s[i+1:]
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid port in registry host %q", s)
+		}
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" || net.ParseIP(host) != nil {
+		if host == "" {
+			return fmt.Errorf("empty host in registry host %q", s)
+		}
+		return nil
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("invalid registry host %q", s)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid registry host %q", s)
+			}
+		}
+	}
+	return nil
+}
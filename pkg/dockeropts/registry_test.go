@@ -0,0 +1,46 @@
+package dockeropts
+
+import (
+	"testing"
+)
+
+func Test_ValidateRegistryMirror(t *testing.T) {
+	for _, c := range []struct {
+		in string
+		ok bool
+	}{
+		{"https://mirror.example.com", true},
+		{"http://10.0.0.4:5000/", true},
+		{"mirror.example.com", false},
+		{"ftp://mirror.example.com", false},
+		{"https://mirror.example.com/v2/library", false},
+		{"https://", false},
+	} {
+		if err := ValidateRegistryMirror(c.in); (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %q, expected ok=%v", err, c.in, c.ok)
+		}
+	}
+}
+
+func Test_ValidateInsecureRegistry(t *testing.T) {
+	for _, c := range []struct {
+		in string
+		ok bool
+	}{
+		{"registry.internal:5000", true},
+		{"registry", true},
+		{"10.0.0.4:5000", true},
+		{"[fd00::1]:5000", true},
+		{"10.0.0.0/8", true},
+		{"10.0.0.0/33", false},
+		{"http://registry.internal", false},
+		{"registry.internal:abc", false},
+		{"registry..internal", false},
+		{"-registry.internal", false},
+		{":5000", false},
+	} {
+		if err := ValidateInsecureRegistry(c.in); (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %q, expected ok=%v", err, c.in, c.ok)
+		}
+	}
+}
@@ -2,21 +2,24 @@ package dockeropts
 
 import (
 	"errors"
-	"fmt"
-	"regexp"
+	"strings"
 )
 
-// SystemdUnitEditor modifies the 'ExecStart=' line as
-// 'ExecStart=/usr/bin/docker $args'. If ExecStart line does not
-// exist, returns error.
+// SystemdUnitEditor modifies the effective 'ExecStart=' of the [Service]
+// section as 'ExecStart=<daemon command> $args' where the daemon command is
+// preserved from the existing ExecStart. Rest of the unit file is preserved
+// as is. If ExecStart does not exist, returns error.
 type SystemdUnitEditor struct{}
 
 func (e SystemdUnitEditor) ChangeOpts(contents, args string) (string, error) {
-	cmd := fmt.Sprintf("ExecStart=/usr/bin/dockerd %s", args)
-	r := regexp.MustCompile("ExecStart=.*")
-
-	if r.FindString(contents) == "" {
+	u, err := ParseUnit(contents)
+	if err != nil {
+		return "", err
+	}
+	execStart := u.ServiceExecStart()
+	if execStart == nil {
 		return "", errors.New("systemd unit editor could not find ExecStart")
 	}
-	return string(r.ReplaceAllString(contents, cmd)), nil
+	execStart.SetValue(strings.TrimSpace(DaemonCommand(execStart.Value) + " " + args))
+	return u.String(), nil
 }
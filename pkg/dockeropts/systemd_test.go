@@ -53,3 +53,31 @@ WantedBy=multi-user.target
 		t.Fatalf("out:%s\nexpected:%s", out, expected)
 	}
 }
+
+func Test_SystemdUnitEditor_PreservesUnit(t *testing.T) {
+	expected := `# vendor unit
+[Unit]
+Description=Docker Application Container Engine
+After=network.target docker.socket
+
+[Service]
+Type=notify
+# the default is not to use systemd for cgroups
+ExecStartPre=/bin/true
+ExecStart=
+ExecStart=/usr/local/bin/dockerd --tlsverify
+ExecReload=/bin/kill -s HUP $MAINPID
+  ; indented comment
+
+[Install]
+WantedBy=multi-user.target
+ExecStart=/not/a/service
+`
+	out, err := SystemdUnitEditor{}.ChangeOpts(testUnit, "--tlsverify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("out:%s\nexpected:%s", out, expected)
+	}
+}
@@ -0,0 +1,222 @@
+package dockeropts
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Unit is a parsed systemd unit file. It keeps the original lines of the file
+// so that the unmodified parts of the file (including comments, blank lines,
+// line continuations and whitespace) are preserved byte-for-byte.
+type Unit struct {
+	Preamble []*UnitEntry // comments and blank lines before the first section
+	Sections []*UnitSection
+}
+
+// UnitSection is a section of a unit file such as [Service]. A section may
+// appear multiple times in a unit file.
+type UnitSection struct {
+	Name    string
+	header  string
+	Entries []*UnitEntry
+}
+
+// UnitEntry is a 'Key=Value' assignment, or a comment or a blank line in a unit
+// file. Values spanning multiple lines with trailing backslashes are joined.
+type UnitEntry struct {
+	Key   string
+	Value string
+	raw   []string // original lines
+}
+
+// IsAssignment reports whether the entry is a 'Key=Value' line (i.e. not a
+// comment or blank line).
+func (e *UnitEntry) IsAssignment() bool { return e.Key != "" }
+
+// SetValue changes the value of the entry. The entry is written as a single
+// line afterwards.
+func (e *UnitEntry) SetValue(v string) {
+	e.Value = v
+	e.raw = []string{fmt.Sprintf("%s=%s", e.Key, v)}
+}
+
+func isUnitComment(line string) bool {
+	l := strings.TrimSpace(line)
+	return l == "" || strings.HasPrefix(l, "#") || strings.HasPrefix(l, ";")
+}
+
+// continues reports whether the line ends with a backslash, which means that
+// the value continues on the next line.
+func continues(line string) bool {
+	return strings.HasSuffix(strings.TrimRight(line, " \t\r"), `\`)
+}
+
+// ParseUnit parses the given unit file contents.
+func ParseUnit(contents string) (*Unit, error) {
+	u := &Unit{}
+	lines := strings.Split(contents, "\n")
+	var cur *UnitSection
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		l := strings.TrimSpace(line)
+
+		if isUnitComment(line) {
+			e := &UnitEntry{raw: []string{line}}
+			if cur == nil {
+				u.Preamble = append(u.Preamble, e)
+			} else {
+				cur.Entries = append(cur.Entries, e)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(l, "[") {
+			if !strings.HasSuffix(l, "]") {
+				return nil, fmt.Errorf("invalid section header at line %d: %q", i+1, line)
+			}
+			cur = &UnitSection{Name: l[1 : len(l)-1], header: line}
+			u.Sections = append(u.Sections, cur)
+			continue
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("assignment outside of a section at line %d: %q", i+1, line)
+		}
+		p := strings.SplitN(line, "=", 2)
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid assignment at line %d: %q", i+1, line)
+		}
+
+		e := &UnitEntry{Key: strings.TrimSpace(p[0]), raw: []string{line}}
+		var value []string
+		v := p[1]
+		for continues(v) && i+1 < len(lines) {
+			value = append(value, strings.TrimSuffix(strings.TrimRight(v, " \t\r"), `\`))
+			i++
+			e.raw = append(e.raw, lines[i])
+			if isUnitComment(lines[i]) && strings.TrimSpace(lines[i]) != "" {
+				v = `\` // comments in continuation lines are skipped
+				continue
+			}
+			v = lines[i]
+		}
+		value = append(value, v)
+		for j := range value {
+			value[j] = strings.TrimSpace(value[j])
+		}
+		e.Value = strings.TrimSpace(strings.Join(nonEmpty(value), " "))
+		cur.Entries = append(cur.Entries, e)
+	}
+	return u, nil
+}
+
+func nonEmpty(l []string) []string {
+	var out []string
+	for _, s := range l {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// String reconstructs the unit file contents.
+func (u *Unit) String() string {
+	var out []string
+	for _, e := range u.Preamble {
+		out = append(out, e.raw...)
+	}
+	for _, s := range u.Sections {
+		out = append(out, s.header)
+		for _, e := range s.Entries {
+			out = append(out, e.raw...)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Entries returns the assignments with the given key in all the sections
+// with the given name, in the order they appear in the file.
+func (u *Unit) Entries(section, key string) []*UnitEntry {
+	var out []*UnitEntry
+	for _, s := range u.Sections {
+		if s.Name != section {
+			continue
+		}
+		for _, e := range s.Entries {
+			if e.IsAssignment() && e.Key == key {
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
+
+// ServiceExecStart returns the ExecStart entry of the [Service] section that
+// starts the daemon, which is the last non-empty ExecStart. Empty ExecStart
+// assignments reset the previous ones. Returns nil if not found.
+func (u *Unit) ServiceExecStart() *UnitEntry {
+	var out *UnitEntry
+	for _, e := range u.Entries("Service", "ExecStart") {
+		if e.Value == "" {
+			out = nil
+		} else {
+			out = e
+		}
+	}
+	return out
+}
+
+// DaemonCommand returns the command used for starting the docker daemon in the
+// given ExecStart value without its arguments, such as '/usr/bin/dockerd' or
+// '/usr/bin/docker daemon' (for older versions of docker). Special executable
+// prefixes of systemd (such as '-' or '@') are preserved.
+func DaemonCommand(execStart string) string {
+	f := strings.Fields(execStart)
+	if len(f) == 0 {
+		return ""
+	}
+	bin := strings.TrimLeft(f[0], "-@+!:")
+	if filepath.Base(bin) == "docker" && len(f) > 1 && f[1] == "daemon" {
+		return f[0] + " daemon"
+	}
+	return f[0]
+}
+
+// DaemonArgs returns the arguments of the docker daemon in the given ExecStart
+// value, except the flags (and their values) that are also given in args or
+// configured in cfg, as dockerd refuses to start with an option specified both
+// as a flag and in daemon.json.
+func DaemonArgs(execStart string, args []string, cfg DaemonConfig) []string {
+	f := strings.Fields(execStart)
+	f = f[len(strings.Fields(DaemonCommand(execStart))):]
+
+	overridden := make(map[string]bool)
+	for _, tok := range args {
+		if strings.HasPrefix(tok, "-") {
+			name, _, _, _, _ := lookupFlag(tok)
+			overridden[name] = true
+		}
+	}
+	var out []string
+	for i := 0; i < len(f); i++ {
+		tok := f[i]
+		if !strings.HasPrefix(tok, "-") {
+			out = append(out, tok)
+			continue
+		}
+		name, _, hasValue, flag, ok := lookupFlag(tok)
+		n := 1
+		if !hasValue && i+1 < len(f) && !strings.HasPrefix(f[i+1], "-") && (!ok || flag.kind != boolFlag) {
+			n = 2 // value given as the next token
+		}
+		_, inCfg := cfg[flag.key]
+		if !overridden[name] && !(ok && inCfg) {
+			out = append(out, f[i:i+n]...)
+		}
+		i += n - 1
+	}
+	return out
+}
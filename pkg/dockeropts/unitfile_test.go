@@ -0,0 +1,115 @@
+package dockeropts
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testUnit = `# vendor unit
+[Unit]
+Description=Docker Application Container Engine
+After=network.target docker.socket
+
+[Service]
+Type=notify
+# the default is not to use systemd for cgroups
+ExecStartPre=/bin/true
+ExecStart=
+ExecStart=/usr/local/bin/dockerd \
+	-H fd:// \
+	# a comment
+	--containerd=/run/containerd/containerd.sock
+ExecReload=/bin/kill -s HUP $MAINPID
+  ; indented comment
+
+[Install]
+WantedBy=multi-user.target
+ExecStart=/not/a/service
+`
+
+func Test_ParseUnit_RoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"\n\n",
+		testUnit,
+		"[Service]\r\nExecStart=/usr/bin/dockerd\r\n",
+		"[Service]\nExecStart=/usr/bin/dockerd \\",
+	} {
+		u, err := ParseUnit(in)
+		if err != nil {
+			t.Fatalf("error parsing %q: %v", in, err)
+		}
+		if out := u.String(); out != in {
+			t.Fatalf("round-trip failed.\nout:%q\nexpected:%q", out, in)
+		}
+	}
+}
+
+func Test_ParseUnit_Bad(t *testing.T) {
+	for _, in := range []string{
+		"ExecStart=/usr/bin/dockerd",
+		"[Service\nExecStart=/usr/bin/dockerd",
+		"[Service]\nExecStart",
+	} {
+		if _, err := ParseUnit(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func Test_ParseUnit_Entries(t *testing.T) {
+	u, err := ParseUnit(testUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, e := range u.Entries("Service", "ExecStart") {
+		values = append(values, e.Value)
+	}
+	expected := []string{"", "/usr/local/bin/dockerd -H fd:// --containerd=/run/containerd/containerd.sock"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("got wrong values: %q, expected: %q", values, expected)
+	}
+	if e := u.ServiceExecStart(); e == nil || e.Value != expected[1] {
+		t.Fatalf("got wrong service ExecStart: %#v", e)
+	}
+}
+
+func Test_DaemonCommand(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"", ""},
+		{"/usr/bin/dockerd -H fd://", "/usr/bin/dockerd"},
+		{"/usr/local/bin/dockerd", "/usr/local/bin/dockerd"},
+		{"/usr/bin/docker daemon -H fd://", "/usr/bin/docker daemon"},
+		{"-/usr/bin/docker -d", "-/usr/bin/docker"},
+	} {
+		if out := DaemonCommand(c.in); out != c.out {
+			t.Fatalf("got %q for %q, expected: %q", out, c.in, c.out)
+		}
+	}
+}
+
+func Test_DaemonArgs(t *testing.T) {
+	for _, c := range []struct {
+		execStart string
+		args      []string
+		cfg       DaemonConfig
+		out       []string
+	}{
+		{"/usr/bin/dockerd", nil, nil, nil},
+		{"/usr/bin/dockerd -H fd:// --containerd=/run/containerd/containerd.sock", []string{"-H=fd://", "--tlsverify"}, nil,
+			[]string{"--containerd=/run/containerd/containerd.sock"}},
+		{"/usr/bin/dockerd -H fd:// --containerd /run/containerd/containerd.sock", []string{"--host=fd://"}, nil,
+			[]string{"--containerd", "/run/containerd/containerd.sock"}},
+		{"/usr/bin/dockerd -H fd:// --containerd=/run/containerd/containerd.sock", []string{"-H=fd://"},
+			DaemonConfig{"containerd": "/run/containerd/other.sock"}, nil},
+		{"/usr/bin/docker daemon -H fd:// --selinux-enabled $OPTIONS", []string{"-H=fd://"}, nil,
+			[]string{"--selinux-enabled", "$OPTIONS"}},
+		{"/usr/bin/dockerd --debug -H fd:// --add-runtime runc=/usr/bin/runc", []string{"-H=fd://", "--debug=false"}, nil,
+			[]string{"--add-runtime", "runc=/usr/bin/runc"}},
+	} {
+		if out := DaemonArgs(c.execStart, c.args, c.cfg); !reflect.DeepEqual(out, c.out) {
+			t.Fatalf("got %q for %q with %v, expected: %q", out, c.execStart, c.args, c.out)
+		}
+	}
+}
@@ -37,3 +37,54 @@ func (e UpstartCfgEditor) ChangeOpts(contents, args string) (string, error) {
 	file := strings.Join(out, "\n")
 	return file, nil
 }
+
+// upstartEnvMarker marks the export lines written by UpstartEnvEditor.
+const upstartEnvMarker = "# managed by docker extension"
+
+// UpstartEnvEditor manages "export KEY='value'" lines of the given variables
+// in the upstart/SysVinit configuration file of docker, which is sourced by
+// the shell. The exports written previously by the editor (marked with a
+// trailing comment) are removed and the ones with non-empty values are
+// appended. Other lines, including the exports of the same variables written
+// by the administrator, are preserved.
+type UpstartEnvEditor struct {
+	Keys []string // variables managed by the editor
+}
+
+func (e UpstartEnvEditor) ChangeEnv(contents string, env map[string]string) (string, error) {
+	var (
+		out     = []string{}
+		sc      = bufio.NewScanner(strings.NewReader(contents))
+		managed = make(map[string]bool)
+	)
+	for _, k := range e.Keys {
+		managed[k] = true
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		l := strings.TrimSpace(line)
+		if strings.HasPrefix(l, "export ") && strings.HasSuffix(l, upstartEnvMarker) {
+			kv := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(l, "export ")), "=", 2)
+			if managed[kv[0]] {
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	for _, k := range e.Keys {
+		if v := env[k]; v != "" {
+			out = append(out, fmt.Sprintf(`export %s=%s %s`, k, shellQuote(v), upstartEnvMarker))
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// shellQuote single-quotes the value so that it is not expanded by the shell
+// (e.g. '$' or '`' in a proxy password).
+func shellQuote(v string) string {
+	return "'" + strings.Replace(v, "'", `'\''`, -1) + "'"
+}
@@ -1,21 +1,196 @@
 package driver
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/executil"
 )
 
+const (
+	// yumRepoFile configures the docker-ce package repository.
+	yumRepoFile = "/etc/yum.repos.d/docker-extension.repo"
+
+	// yumKeyFile is the verified key of the docker-ce package repository
+	// served over plain http, which is not downloaded by yum.
+	yumKeyFile = "/etc/pki/rpm-gpg/RPM-GPG-KEY-docker-extension"
+)
+
 // CentOSDriver is for CentOS-based distros.
 type CentOSDriver struct {
 	systemdBaseDriver
-	systemdUnitOverwriteDriver
+	systemdDropInDriver
+}
+
+// enginePackages returns the docker engine packages installed from the source.
+func (c CentOSDriver) enginePackages(src PackageSource) []string {
+	if src.Type == SourceDistro {
+		return []string{"docker"}
+	}
+	return []string{"docker-ce", "docker-ce-cli"}
+}
+
+func (c CentOSDriver) InstallDocker(src PackageSource) error {
+	if err := c.configureRepo(src); err != nil {
+		return fmt.Errorf("error configuring package repository: %v", err)
+	}
+	return executil.ExecPipe("yum", append([]string{"-y", "-q", "install"}, c.enginePackages(src)...)...)
+}
+
+func (c CentOSDriver) InstallRootlessPackages(src PackageSource) error {
+	if src.Type == SourceDistro {
+		return errors.New("rootless docker requires docker-ce packages")
+	}
+	return executil.ExecPipe("yum", "-y", "-q", "install", "shadow-utils", "fuse-overlayfs", "docker-ce-rootless-extras")
+}
+
+// configureRepo adds the docker-ce package repository (and its key, which is
+// imported by yum from gpgkey) to yum repositories, or removes it if distro
+// packages are used.
+func (c CentOSDriver) configureRepo(src PackageSource) error {
+	files := []string{yumKeyFile, yumRepoFile}
+	for _, f := range files {
+		if err := backup.Save(f); err != nil {
+			return err
+		}
+	}
+	if src.Type == SourceDistro {
+		for _, f := range files {
+			if err := os.RemoveAll(f); err != nil {
+				return fmt.Errorf("error removing %s: %v", f, err)
+			}
+		}
+		return nil
+	}
+
+	repo := src.repoURL("centos")
+	gpgkey := repo + "/gpg"
+	if !strings.HasPrefix(repo, "https://") {
+		key, err := downloadRepoKey(repo, yumKeyFingerprint)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(yumKeyFile, key, 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", yumKeyFile, err)
+		}
+		gpgkey = "file://" + yumKeyFile
+	}
+	cfg := fmt.Sprintf(`[docker-extension]
+name=Docker CE (configured by Azure Docker extension)
+baseurl=%s/$releasever/$basearch/stable
+enabled=1
+gpgcheck=1
+gpgkey=%s
+`, repo, gpgkey)
+	if err := ioutil.WriteFile(yumRepoFile, []byte(cfg), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", yumRepoFile, err)
+	}
+	return nil
 }
 
-func (c CentOSDriver) InstallDocker(installCmd string) error {
-	return executil.ExecPipe("/bin/sh", "-c", installCmd)
+func (c CentOSDriver) InstallDockerPackages(paths []string) error {
+	// dependencies are resolved among the given packages only
+	return executil.ExecPipe("yum", append([]string{"-y", "-q", "--disablerepo=*", "localinstall"}, paths...)...)
 }
 
+func (c CentOSDriver) DockerVersion() (string, error) {
+	for _, pkg := range []string{"docker-ce", "docker"} {
+		out, err := executil.Exec("rpm", "-q", "--queryformat", "%{EPOCH}:%{VERSION}-%{RELEASE}", pkg)
+		if err == nil {
+			return strings.TrimPrefix(strings.TrimSpace(string(out)), "(none):"), nil
+		}
+	}
+	return "", nil // not installed
+}
+
+func (c CentOSDriver) InstallDockerVersion(src PackageSource, version string) error {
+	return yumInstallVersion(c.enginePackages(src), version, c.DockerVersion, c.UnpinDockerVersion)
+}
+
+// yumInstallVersion installs the specified version of the engine packages
+// (the first one is the package determining the version) and locks them.
+func yumInstallVersion(engine []string, version string, installed func() (string, error), unpin func() error) error {
+	out, err := executil.Exec("yum", "list", "-q", "--showduplicates", engine[0])
+	if err != nil {
+		return fmt.Errorf("cannot list available %s versions: %v", engine[0], err)
+	}
+	// yum lists the versions from the oldest to the newest
+	l := parseColumn(string(out), "", 1)
+	for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+		l[i], l[j] = l[j], l[i]
+	}
+	pv, ok := pickVersion(l, version)
+	if !ok {
+		return fmt.Errorf("%s version %s is not available in the package repository", engine[0], version)
+	}
+
+	pkgs := []string{engine[0] + "-" + stripEpoch(pv)}
+	for _, p := range engine[1:] {
+		if _, err := executil.Exec("yum", "list", "-q", p+"-"+stripEpoch(pv)); err == nil {
+			pkgs = append(pkgs, p+"-"+stripEpoch(pv))
+		}
+	}
+
+	if err := executil.ExecPipe("yum", "-y", "-q", "install", "yum-plugin-versionlock"); err != nil {
+		return err
+	}
+	_ = unpin()
+	if err := executil.ExecPipe("yum", append([]string{"-y", "-q", "install"}, pkgs...)...); err != nil {
+		return err
+	}
+	// 'yum install' does not downgrade already installed packages
+	if v, _ := installed(); !VersionMatches(v, version) {
+		if err := executil.ExecPipe("yum", append([]string{"-y", "-q", "downgrade"}, pkgs...)...); err != nil {
+			return err
+		}
+	}
+	return executil.ExecPipe("yum", append([]string{"versionlock", "add"}, engine...)...)
+}
+
+func (c CentOSDriver) UnpinDockerVersion() error {
+	// error is ignored as the packages may not be locked or versionlock
+	// plugin may not be installed
+	_, _ = executil.Exec("yum", "versionlock", "delete", "*:docker-ce-*", "*:docker-[0-9]*")
+	return nil
+}
+
+// UninstallDocker removes the engine packages of any package source (and the
+// legacy docker-engine package) that are installed, and their dependencies
+// such as containerd.io.
 func (c CentOSDriver) UninstallDocker() error {
-	return executil.ExecPipe("yum", "-y", "-q", "remove", "docker-engine.x86_64")
+	candidates := append(c.enginePackages(PackageSource{Type: SourceDockerCE}),
+		append(c.enginePackages(PackageSource{Type: SourceDistro}), "docker-engine")...)
+	var pkgs []string
+	for _, p := range candidates {
+		if _, err := executil.Exec("rpm", "-q", p); err == nil {
+			pkgs = append(pkgs, p)
+		}
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+	return executil.ExecPipe("yum", append([]string{"-y", "-q", "--setopt=clean_requirements_on_remove=1", "remove"}, pkgs...)...)
+}
+
+// SocketActivated is false as the daemon is started with -H=unix:// (see
+// BaseOpts) and creates the socket itself.
+func (c CentOSDriver) SocketActivated() bool { return false }
+
+// UpdateDockerSocket removes the docker.socket drop-in written by the older
+// versions of the extension, which has no effect without socket activation.
+// Returns error if a socket group or mode is specified.
+func (c CentOSDriver) UpdateDockerSocket(group string, mode os.FileMode) (bool, error) {
+	if group != "" || mode != 0 {
+		return false, errors.New("the unix socket is created by the daemon, configure its group in daemon.json")
+	}
+	if _, err := removeDropIn(systemdSocketDropInDir, systemdDropInFile); err != nil {
+		return false, err
+	}
+	return false, nil
 }
 
 func (c CentOSDriver) DockerComposeDir() string { return "/usr/local/bin" }
@@ -1,13 +1,13 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
-	"github.com/Azure/azure-docker-extension/pkg/util"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
 )
 
 // CoreOS: distro already comes with docker installed and
@@ -16,10 +16,33 @@ type CoreOSDriver struct {
 	systemdBaseDriver
 }
 
-func (c CoreOSDriver) InstallDocker(installCmd string) error {
+func (c CoreOSDriver) InstallDocker(src PackageSource) error {
 	log.Println("CoreOS: docker already installed, noop")
 	return nil
 }
+func (c CoreOSDriver) InstallDockerPackages(paths []string) error {
+	log.Println("CoreOS: docker already installed, noop")
+	return nil
+}
+
+func (c CoreOSDriver) DockerVersion() (string, error) {
+	out, err := executil.Exec("docker", "version", "--format", "{{.Server.Version}}")
+	if err != nil {
+		return "", fmt.Errorf("cannot get docker version: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (c CoreOSDriver) InstallDockerVersion(src PackageSource, version string) error {
+	return errors.New("CoreOS: docker version is determined by the OS release and cannot be changed")
+}
+
+func (c CoreOSDriver) UnpinDockerVersion() error { return nil }
+
+func (c CoreOSDriver) InstallRootlessPackages(src PackageSource) error {
+	return errors.New("CoreOS: rootless docker is not supported")
+}
+
 func (c CoreOSDriver) UninstallDocker() error {
 	log.Println("CoreOS: docker cannot be uninstalled, noop")
 	return nil
@@ -29,31 +52,17 @@ func (c CoreOSDriver) DockerComposeDir() string { return "/opt/bin" }
 
 func (c CoreOSDriver) BaseOpts() []string { return []string{} }
 
+// UpdateDockerArgs passes the args through DOCKER_OPTS as the docker.service
+// shipped with CoreOS already reads it (unlike other distros, ExecStart of
+// the vendor unit has CoreOS-specific arguments that should not be reset).
 func (c CoreOSDriver) UpdateDockerArgs(args string) (bool, error) {
-	const dropInDir = "/run/systemd/system/docker.service.d"
-	const dropInFile = "10-docker-extension.conf"
-	filePath := filepath.Join(dropInDir, dropInFile)
+	// older versions of the extension wrote a non-persistent drop-in
+	const legacyDropIn = "/run/systemd/system/docker.service.d/10-docker-extension.conf"
+	if err := os.RemoveAll(legacyDropIn); err != nil {
+		return false, fmt.Errorf("error removing %s: %v", legacyDropIn, err)
+	}
 
 	config := fmt.Sprintf(`[Service]
 Environment="DOCKER_OPTS=%s"`, args)
-
-	// check if config file exists and needs an update
-	if ok, _ := util.PathExists(filePath); ok {
-		existing, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			return false, fmt.Errorf("error reading %s: %v", filePath, err)
-		}
-
-		// no need to update config or restart service if goal config is already there
-		if string(existing) == config {
-			return false, nil
-		}
-	}
-
-	if err := os.MkdirAll(dropInDir, 0755); err != nil {
-		return false, fmt.Errorf("error creating %s dir: %v", dropInDir, err)
-	}
-	err := ioutil.WriteFile(filePath, []byte(config), 0644)
-	log.Println("Written systemd service drop-in to disk.")
-	return true, err
+	return writeDropIn(systemdDropInDir, systemdDropInFile, config, 0644)
 }
@@ -2,23 +2,80 @@ package driver
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-docker-extension/pkg/distro"
+	"github.com/Azure/azure-docker-extension/pkg/util"
 )
 
+// proxyEnvKeys are the environment variables configuring the proxy of the
+// docker daemon, in the order they are written.
+var proxyEnvKeys = []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"}
+
 type DistroDriver interface {
-	InstallDocker(installCmd string) error
+	// InstallDocker configures the package repository of the given source
+	// and installs docker engine from it.
+	InstallDocker(src PackageSource) error
+	// InstallDockerPackages installs docker engine from the given local
+	// package files without network access.
+	InstallDockerPackages(paths []string) error
 	DockerComposeDir() string
 
+	// DockerVersion returns the version of the installed docker engine
+	// package, or empty string if not installed.
+	DockerVersion() (string, error)
+	// InstallDockerVersion installs the specified version of docker engine
+	// (upgrading or downgrading if necessary) and pins it.
+	InstallDockerVersion(src PackageSource, version string) error
+	// UnpinDockerVersion allows docker engine to be upgraded by the
+	// package manager again.
+	UnpinDockerVersion() error
+
 	BaseOpts() []string
 	UpdateDockerArgs(args string) (restartNeeded bool, err error)
+	// UpdateDockerProxy configures the proxy environment variables of the
+	// docker daemon (removing them if no proxy is configured).
+	UpdateDockerProxy(p util.ProxyConfig) (restartNeeded bool, err error)
+	// SocketActivated reports whether the unix socket of the docker daemon
+	// is created by systemd (docker.socket). Otherwise the daemon creates
+	// the socket itself, with the group in daemon.json and mode 0660.
+	SocketActivated() bool
+	// UpdateDockerSocket configures the group and mode of the unix socket
+	// created by systemd (defaults are used if empty/zero).
+	UpdateDockerSocket(group string, mode os.FileMode) (restartNeeded bool, err error)
+	// UpdateDockerMounts makes the docker daemon wait for the file systems
+	// of the given paths to be mounted before starting.
+	UpdateDockerMounts(paths []string) (restartNeeded bool, err error)
+
+	// InstallRootlessPackages installs the packages required for running
+	// the docker engine as a non-root user.
+	InstallRootlessPackages(src PackageSource) error
+	// SetDockerAutostart enables or disables (and stops) the system docker
+	// daemon from starting at boot.
+	SetDockerAutostart(enabled bool) error
+
+	// UpdatePruneSchedule runs the given command periodically on the given
+	// schedule (hourly, daily, weekly or monthly), or removes the schedule
+	// if it is empty.
+	UpdatePruneSchedule(schedule, cmd string) error
 
 	RestartDocker() error
+	// RestartDockerUnits restarts the docker daemon along with the other
+	// units configured by the given files, which are modified outside of
+	// the Update methods (e.g. rolled back).
+	RestartDockerUnits(paths []string) error
+	// ReloadDockerUnits makes the init system pick up the units configured
+	// by the given files (e.g. restored) without starting the docker daemon.
+	ReloadDockerUnits(paths []string) error
 	StartDocker() error
 	StopDocker() error
 	UninstallDocker() error
+
+	// DaemonLogs returns the logs of the docker daemon since the given time.
+	DaemonLogs(since time.Time) (string, error)
 }
 
 func GetDriver(d distro.Info) (DistroDriver, error) {
@@ -40,9 +97,9 @@ func GetDriver(d distro.Info) (DistroDriver, error) {
 		if major < 13 {
 			return nil, fmt.Errorf("Ubuntu 12 or older not supported. Got: %s", d)
 		} else if major < 15 {
-			return UbuntuUpstartDriver{}, nil
+			return UbuntuUpstartDriver{ubuntuBaseDriver: ubuntuBaseDriver{d.Codename}}, nil
 		} else {
-			return UbuntuSystemdDriver{}, nil
+			return UbuntuSystemdDriver{ubuntuBaseDriver: ubuntuBaseDriver{d.Codename}}, nil
 		}
 	} else if d.Id == distro.RhelID {
 		return RHELDriver{}, nil
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"testing"
+)
+
+func Test_SocketActivated(t *testing.T) {
+	for _, c := range []struct {
+		d         DistroDriver
+		activated bool
+	}{
+		{UbuntuSystemdDriver{}, true},
+		{UbuntuUpstartDriver{}, false},
+		{CentOSDriver{}, false},
+		{RHELDriver{}, false},
+		{CoreOSDriver{}, true},
+	} {
+		if c.d.SocketActivated() != c.activated {
+			t.Fatalf("%T: got SocketActivated()=%v, expected: %v", c.d, c.d.SocketActivated(), c.activated)
+		}
+		// the daemon gets its socket from systemd only if started with fd://
+		for _, o := range c.d.BaseOpts() {
+			if (o == "-H=fd://" && !c.activated) || (o == "-H=unix://" && c.activated) {
+				t.Fatalf("%T: daemon is started with %s, expected socket activation=%v", c.d, o, c.activated)
+			}
+		}
+		if c.activated {
+			continue
+		}
+		// the socket group is configured in daemon.json instead
+		if _, err := c.d.UpdateDockerSocket("docker", 0); err == nil {
+			t.Fatalf("%T: expected error for socket group without socket activation", c.d)
+		}
+		if _, err := c.d.UpdateDockerSocket("", 0660); err == nil {
+			t.Fatalf("%T: expected error for socket mode without socket activation", c.d)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/util"
+)
+
+const (
+	// podmanSocket is the Docker-compatible API socket of Podman, activated
+	// by podman.socket.
+	podmanSocket = "/run/podman/podman.sock"
+
+	podmanDropInDir         = "/etc/systemd/system/podman.service.d"
+	containersConfDropInDir = "/etc/containers/containers.conf.d"
+	registriesConfDropInDir = "/etc/containers/registries.conf.d"
+	podmanConfDropInFile    = "docker-extension.conf"
+)
+
+// CompatEngine is implemented by the drivers of container engines serving a
+// Docker-compatible API instead of the docker daemon.
+type CompatEngine interface {
+	// EngineName returns the name (and the command) of the engine.
+	EngineName() string
+	// DockerHost returns the address of the Docker-compatible API.
+	DockerHost() string
+	// UpdateEngineConfig maps the daemon.json configuration to the
+	// configuration files of the engine.
+	UpdateEngineConfig(cfg dockeropts.DaemonConfig) (restartNeeded bool, err error)
+}
+
+// PodmanDriver installs Podman with its Docker-compatible API socket instead
+// of docker engine on RHEL-family distros.
+type PodmanDriver struct {
+	DistroDriver
+}
+
+// NewPodmanDriver returns the Podman driver for the given distro driver.
+func NewPodmanDriver(d DistroDriver) (DistroDriver, error) {
+	switch d.(type) {
+	case CentOSDriver, RHELDriver:
+		return PodmanDriver{d}, nil
+	}
+	return nil, errors.New("podman engine is only supported on RHEL and CentOS")
+}
+
+func (p PodmanDriver) EngineName() string { return "podman" }
+
+func (p PodmanDriver) DockerHost() string { return "unix://" + podmanSocket }
+
+func (p PodmanDriver) InstallDocker(src PackageSource) error {
+	if src.Type != SourceDockerCE || src.URL != "" {
+		return errors.New("podman is installed from the distro repositories, install-source is not supported")
+	}
+	// podman-docker provides the docker command running podman
+	return executil.ExecPipe("yum", "-y", "-q", "install", "podman", "podman-docker")
+}
+
+func (p PodmanDriver) DockerVersion() (string, error) {
+	out, err := executil.Exec("rpm", "-q", "--queryformat", "%{EPOCH}:%{VERSION}-%{RELEASE}", "podman")
+	if err != nil {
+		return "", nil // not installed
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "(none):"), nil
+}
+
+func (p PodmanDriver) InstallDockerVersion(src PackageSource, version string) error {
+	return yumInstallVersion([]string{"podman", "podman-docker"}, version, p.DockerVersion, p.UnpinDockerVersion)
+}
+
+func (p PodmanDriver) UnpinDockerVersion() error {
+	// error is ignored as the packages may not be locked or versionlock
+	// plugin may not be installed
+	_, _ = executil.Exec("yum", "versionlock", "delete", "*:podman-*")
+	return nil
+}
+
+func (p PodmanDriver) UninstallDocker() error {
+	return executil.ExecPipe("yum", "-y", "-q", "remove", "podman-docker", "podman")
+}
+
+func (p PodmanDriver) BaseOpts() []string { return nil }
+
+// UpdateDockerArgs returns error for any arguments, as the Podman API service
+// has no command line options corresponding to the docker daemon options.
+func (p PodmanDriver) UpdateDockerArgs(args string) (bool, error) {
+	if args != "" {
+		return false, fmt.Errorf("daemon options %q are not supported by podman", args)
+	}
+	return false, nil
+}
+
+func (p PodmanDriver) UpdateDockerProxy(proxy util.ProxyConfig) (bool, error) {
+	return updateProxyDropIn(podmanDropInDir, proxy)
+}
+
+func (p PodmanDriver) UpdateDockerSocket(group string, mode os.FileMode) (bool, error) {
+	if group != "" || mode != 0 {
+		return false, errors.New("configuring the unix socket is not supported by podman")
+	}
+	return false, nil
+}
+
+func (p PodmanDriver) UpdateDockerMounts(paths []string) (bool, error) {
+	if len(paths) > 0 {
+		return false, errors.New("configuring mount dependencies is not supported by podman")
+	}
+	return false, nil
+}
+
+func (p PodmanDriver) InstallRootlessPackages(src PackageSource) error {
+	return errors.New("rootless mode is not supported by podman engine")
+}
+
+func (p PodmanDriver) SetDockerAutostart(enabled bool) error {
+	if !enabled {
+		return errors.New("disabling podman is not supported")
+	}
+	return executil.ExecPipe("systemctl", "enable", "podman.socket")
+}
+
+// RestartDocker restarts the socket and stops the API service (which is
+// started again on the next request) to pick up the new configuration.
+func (p PodmanDriver) RestartDocker() error {
+	if err := executil.ExecPipe("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := executil.ExecPipe("systemctl", "enable", "podman.socket"); err != nil {
+		return err
+	}
+	if err := executil.ExecPipe("systemctl", "stop", "podman.service"); err != nil {
+		return err
+	}
+	return executil.ExecPipe("systemctl", "restart", "podman.socket")
+}
+
+// RestartDockerUnits restarts podman, which reads all of its configuration
+// upon start.
+func (p PodmanDriver) RestartDockerUnits(paths []string) error {
+	return p.RestartDocker()
+}
+
+func (p PodmanDriver) StartDocker() error {
+	return executil.ExecPipe("systemctl", "start", "podman.socket")
+}
+
+func (p PodmanDriver) StopDocker() error {
+	return executil.ExecPipe("systemctl", "stop", "podman.socket", "podman.service")
+}
+
+func (p PodmanDriver) DaemonLogs(since time.Time) (string, error) {
+	out, err := executil.Exec("journalctl", "-u", "podman", "--no-pager", "-o", "cat",
+		"--since", since.Format("2006-01-02 15:04:05"))
+	return string(out), err
+}
+
+// UpdateEngineConfig writes the daemon.json configuration to containers.conf
+// and registries.conf drop-ins, or removes the drop-ins if they are empty.
+func (p PodmanDriver) UpdateEngineConfig(cfg dockeropts.DaemonConfig) (bool, error) {
+	containers, registries, err := podmanConfig(cfg)
+	if err != nil {
+		return false, err
+	}
+	var changed bool
+	for _, f := range []struct{ dir, contents string }{
+		{containersConfDropInDir, containers},
+		{registriesConfDropInDir, registries},
+	} {
+		var c bool
+		if f.contents == "" {
+			c, err = removeDropIn(f.dir, podmanConfDropInFile)
+		} else {
+			c, err = writeDropIn(f.dir, podmanConfDropInFile, f.contents, 0644)
+		}
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+	return changed, nil
+}
+
+// podmanConfig maps the daemon.json configuration to the containers.conf and
+// registries.conf contents. Returns error listing the configuration keys that
+// have no Podman counterpart.
+func podmanConfig(cfg dockeropts.DaemonConfig) (containers, registries string, err error) {
+	var c, unsupported []string
+	for _, k := range cfg.Keys() {
+		v := cfg[k]
+		switch k {
+		case "log-driver":
+			d, _ := v.(string)
+			switch d {
+			case "json-file", "local":
+				d = "k8s-file"
+			case "journald":
+			default:
+				unsupported = append(unsupported, fmt.Sprintf("log-driver=%v", v))
+				continue
+			}
+			c = append(c, fmt.Sprintf("log_driver = %s", strconv.Quote(d)))
+		case "log-opts":
+			for _, o := range sortedKeys(stringMap(v)) {
+				val := stringMap(v)[o]
+				switch o {
+				case "max-size":
+					n, err := parseSize(val)
+					if err != nil {
+						return "", "", fmt.Errorf("invalid log-opts max-size: %v", err)
+					}
+					c = append(c, fmt.Sprintf("log_size_max = %d", n))
+				case "max-file":
+					// podman keeps a single log file per container
+				default:
+					unsupported = append(unsupported, "log-opts "+o)
+				}
+			}
+		case "dns":
+			c = append(c, fmt.Sprintf("dns_servers = %s", tomlArray(stringList(v))))
+		case "dns-search":
+			c = append(c, fmt.Sprintf("dns_searches = %s", tomlArray(stringList(v))))
+		case "dns-opts":
+			c = append(c, fmt.Sprintf("dns_options = %s", tomlArray(stringList(v))))
+		case "registry-mirrors":
+			registries += "[[registry]]\nprefix = \"docker.io\"\nlocation = \"docker.io\"\n"
+			for _, m := range stringList(v) {
+				host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(m, "https://"), "http://"), "/")
+				registries += fmt.Sprintf("\n[[registry.mirror]]\nlocation = %s\n", strconv.Quote(host))
+				if strings.HasPrefix(m, "http://") {
+					registries += "insecure = true\n"
+				}
+			}
+			registries += "\n"
+		case "insecure-registries":
+			for _, r := range stringList(v) {
+				if _, _, err := net.ParseCIDR(r); err == nil {
+					unsupported = append(unsupported, "insecure-registries "+r)
+					continue
+				}
+				registries += fmt.Sprintf("[[registry]]\nlocation = %s\ninsecure = true\n\n", strconv.Quote(r))
+			}
+		default:
+			unsupported = append(unsupported, k)
+		}
+	}
+	if len(unsupported) > 0 {
+		return "", "", fmt.Errorf("daemon configuration not supported by podman: %s", strings.Join(unsupported, ", "))
+	}
+	const header = "# configured by the Azure Docker extension\n"
+	if len(c) > 0 {
+		containers = header + "[containers]\n" + strings.Join(c, "\n") + "\n"
+	}
+	if registries != "" {
+		registries = header + strings.TrimSuffix(registries, "\n")
+	}
+	return containers, registries, nil
+}
+
+// parseSize parses the sizes in the docker format, such as "10m" (in binary
+// units).
+func parseSize(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "b")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// stringList returns the list of strings in a daemon.json value.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var out []string
+		for _, e := range v {
+			out = append(out, fmt.Sprint(e))
+		}
+		return out
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
+// stringMap returns the map of strings in a daemon.json value.
+func stringMap(v interface{}) map[string]string {
+	switch v := v.(type) {
+	case map[string]string:
+		return v
+	case map[string]interface{}:
+		out := make(map[string]string, len(v))
+		for k, e := range v {
+			out[k] = fmt.Sprint(e)
+		}
+		return out
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func tomlArray(l []string) string {
+	q := make([]string, len(l))
+	for i, s := range l {
+		q[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+)
+
+func Test_podmanConfig(t *testing.T) {
+	containers, registries, err := podmanConfig(dockeropts.DaemonConfig{
+		"log-driver":          "json-file",
+		"log-opts":            map[string]interface{}{"max-size": "10m", "max-file": "3"},
+		"dns":                 []interface{}{"8.8.8.8"},
+		"registry-mirrors":    []string{"https://mirror.example.com"},
+		"insecure-registries": []string{"registry.local:5000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# configured by the Azure Docker extension
+[containers]
+dns_servers = ["8.8.8.8"]
+log_driver = "k8s-file"
+log_size_max = 10485760
+`
+	if containers != expected {
+		t.Fatalf("got wrong containers.conf:\n%s\nexpected:\n%s", containers, expected)
+	}
+	expected = `# configured by the Azure Docker extension
+[[registry]]
+location = "registry.local:5000"
+insecure = true
+
+[[registry]]
+prefix = "docker.io"
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+`
+	if registries != expected {
+		t.Fatalf("got wrong registries.conf:\n%s\nexpected:\n%s", registries, expected)
+	}
+
+	if c, r, err := podmanConfig(nil); err != nil || c != "" || r != "" {
+		t.Fatalf("expected empty config, got %q %q %v", c, r, err)
+	}
+	for _, cfg := range []dockeropts.DaemonConfig{
+		{"live-restore": true},
+		{"log-driver": "fluentd"},
+		{"insecure-registries": []string{"10.0.0.0/8"}},
+		{"log-opts": map[string]string{"max-size": "big"}},
+	} {
+		if _, _, err := podmanConfig(cfg); err == nil {
+			t.Fatalf("expected error for %v", cfg)
+		}
+	}
+}
+
+func Test_parseSize(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out int64
+		err bool
+	}{
+		{"100", 100, false},
+		{"10k", 10 << 10, false},
+		{"10m", 10 << 20, false},
+		{"2GB", 2 << 30, false},
+		{"", 0, true},
+		{"b", 0, true},
+		{"-1m", 0, true},
+		{"m", 0, true},
+	} {
+		n, err := parseSize(c.in)
+		if c.err != (err != nil) || n != c.out {
+			t.Fatalf("parseSize(%q) = %d, %v; expected %d (error: %v)", c.in, n, err, c.out, c.err)
+		}
+	}
+}
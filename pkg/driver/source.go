@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/util"
+)
+
+const (
+	// SourceDockerCE installs docker-ce packages from the upstream Docker
+	// package repository (or a mirror of it, if URL is specified).
+	SourceDockerCE = "docker-ce"
+	// SourceMirror installs docker-ce packages from a mirror of the upstream
+	// Docker package repository at URL.
+	SourceMirror = "mirror"
+	// SourceDistro installs the docker packages maintained by the distro.
+	SourceDistro = "distro"
+
+	// DefaultRepoURL is the upstream Docker package repository.
+	DefaultRepoURL = "https://download.docker.com"
+
+	// aptKeyFingerprint and yumKeyFingerprint are the fingerprints of the
+	// keys the upstream Docker package repositories are signed with.
+	aptKeyFingerprint = "9DC858229FC7DD38854AE2D88D81803C0EBFCD88"
+	yumKeyFingerprint = "060A61C51B558A7F742B77AAC52FEB6B621E9F35"
+)
+
+// PackageSource describes where the docker engine packages are installed from.
+type PackageSource struct {
+	Type string
+	URL  string // base URL of the repository, in download.docker.com layout
+}
+
+// Validate returns error if the package source is not valid.
+func (s PackageSource) Validate() error {
+	switch s.Type {
+	case SourceDockerCE, SourceDistro:
+	case SourceMirror:
+		if s.URL == "" {
+			return fmt.Errorf("url is required for package source %q", s.Type)
+		}
+	default:
+		return fmt.Errorf("invalid package source: %q", s.Type)
+	}
+	if s.URL != "" {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return fmt.Errorf("invalid package repository url %q: %v", s.URL, err)
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("invalid package repository url %q: scheme should be http or https", s.URL)
+		}
+	}
+	return nil
+}
+
+// repoURL returns the URL of the repository for the given distro (such as
+// 'ubuntu' or 'centos') in download.docker.com layout.
+func (s PackageSource) repoURL(distro string) string {
+	base := s.URL
+	if base == "" {
+		base = DefaultRepoURL
+	}
+	return fmt.Sprintf("%s/linux/%s", strings.TrimRight(base, "/"), distro)
+}
+
+// downloadRepoKey downloads the key of the repository at the given URL. A key
+// downloaded over plain http is only accepted if it has the given fingerprint
+// (i.e. the repository mirrors the upstream packages), as it may be tampered.
+func downloadRepoKey(repo, fingerprint string) ([]byte, error) {
+	key, err := util.Download(repo + "/gpg")
+	if err != nil {
+		return nil, fmt.Errorf("error downloading repository key: %v", err)
+	}
+	if strings.HasPrefix(repo, "https://") {
+		return key, nil
+	}
+	out, err := executil.ExecWithStdin(ioutil.NopCloser(bytes.NewReader(key)), "gpg", "--with-colons", "--with-fingerprint")
+	if err != nil {
+		return nil, fmt.Errorf("error reading repository key: %v", err)
+	}
+	if l := keyFingerprints(string(out)); len(l) != 1 || l[0] != fingerprint {
+		return nil, fmt.Errorf("repository key downloaded over http is not the Docker repository key %s (got: %s), use an https url for the repository", fingerprint, strings.Join(l, ", "))
+	}
+	return key, nil
+}
+
+// keyFingerprints returns the fingerprints of the primary keys (not the
+// subkeys) in the gpg --with-colons output.
+func keyFingerprints(colons string) []string {
+	var out []string
+	var primary bool
+	for _, l := range strings.Split(colons, "\n") {
+		f := strings.Split(strings.TrimSpace(l), ":")
+		switch f[0] {
+		case "pub", "sub":
+			primary = f[0] == "pub"
+		case "fpr":
+			if primary && len(f) > 9 {
+				out = append(out, f[9])
+			}
+			primary = false
+		}
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"testing"
+)
+
+func Test_PackageSource_Validate(t *testing.T) {
+	for _, c := range []struct {
+		src PackageSource
+		ok  bool
+	}{
+		{PackageSource{Type: SourceDockerCE}, true},
+		{PackageSource{Type: SourceDistro}, true},
+		{PackageSource{Type: SourceMirror, URL: "https://mirror.azure.cn/docker-ce"}, true},
+		{PackageSource{Type: SourceMirror}, false},
+		{PackageSource{Type: SourceMirror, URL: "ftp://example.com"}, false},
+		{PackageSource{Type: "get.docker.com"}, false},
+	} {
+		if err := c.src.Validate(); (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %#v, expected ok=%v", err, c.src, c.ok)
+		}
+	}
+}
+
+func Test_PackageSource_repoURL(t *testing.T) {
+	for _, c := range []struct {
+		src PackageSource
+		out string
+	}{
+		{PackageSource{Type: SourceDockerCE}, "https://download.docker.com/linux/ubuntu"},
+		{PackageSource{Type: SourceMirror, URL: "https://mirror.azure.cn/docker-ce/"}, "https://mirror.azure.cn/docker-ce/linux/ubuntu"},
+	} {
+		if out := c.src.repoURL("ubuntu"); out != c.out {
+			t.Fatalf("got %q, expected: %q", out, c.out)
+		}
+	}
+}
+
+func Test_keyFingerprints(t *testing.T) {
+	out := `pub:-:4096:1:8D81803C0EBFCD88:1487788586:::-:Docker Release (CE deb) <docker@docker.com>::scESCA:
+fpr:::::::::9DC858229FC7DD38854AE2D88D81803C0EBFCD88:
+sub:-:4096:1:7EA0A9C3F273FCD8:1487792064::::::s:
+fpr:::::::::D3306A018370199E527AE7317EA0A9C3F273FCD8:
+`
+	l := keyFingerprints(out)
+	if len(l) != 1 || l[0] != aptKeyFingerprint {
+		t.Fatalf("got wrong fingerprints: %v", l)
+	}
+	if l := keyFingerprints(""); len(l) != 0 {
+		t.Fatalf("expected no fingerprints, got: %v", l)
+	}
+}
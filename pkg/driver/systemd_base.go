@@ -1,10 +1,61 @@
 package driver
 
 import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
 	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/util"
+)
+
+const (
+	// systemdDropInDir is the persistent drop-in directory for docker.service,
+	// it is not modified by docker package upgrades.
+	systemdDropInDir = "/etc/systemd/system/docker.service.d"
+
+	// systemdDropInFile is the name of the drop-in that configures the start
+	// arguments of the docker daemon.
+	systemdDropInFile = "10-docker-extension.conf"
+
+	// systemdProxyDropInFile is the name of the drop-in that configures the
+	// proxy environment variables of the docker daemon.
+	systemdProxyDropInFile = "20-docker-extension-proxy.conf"
+
+	// systemdMountsDropInFile is the name of the drop-in that makes the
+	// docker daemon depend on the mounts it uses.
+	systemdMountsDropInFile = "30-docker-extension-mounts.conf"
+
+	// systemdSocketDropInDir is the persistent drop-in directory for
+	// docker.socket, which provides the unix socket of the daemon.
+	systemdSocketDropInDir = "/etc/systemd/system/docker.socket.d"
+
+	// dockerDaemonConfig is the configuration file of the docker daemon.
+	dockerDaemonConfig = "/etc/docker/daemon.json"
+
+	// systemdVendorUnit is the docker.service installed by the docker package.
+	systemdVendorUnit = "/lib/systemd/system/docker.service"
+
+	// systemdUnitDir is the directory of the units installed by the extension.
+	systemdUnitDir = "/etc/systemd/system"
+
+	// systemdPruneUnit is the name of the service and timer units pruning
+	// the unused docker objects.
+	systemdPruneUnit = "docker-extension-prune"
 )
 
+// inPlaceEditRegexp matches the ExecStart lines written to the vendor unit
+// file by the older versions of the extension (which used "-H=" form of the
+// flag unlike the vendor unit files).
+var inPlaceEditRegexp = regexp.MustCompile(`(?m)^ExecStart=/usr/bin/docker(d| daemon) -H=`)
+
 type systemdBaseDriver struct{}
 
 func (d systemdBaseDriver) RestartDocker() error {
@@ -14,6 +65,37 @@ func (d systemdBaseDriver) RestartDocker() error {
 	return executil.ExecPipe("systemctl", "restart", "docker")
 }
 
+// RestartDockerUnits restarts docker.socket as well if its drop-ins are
+// among the given paths, so that the socket configuration is reloaded.
+func (d systemdBaseDriver) RestartDockerUnits(paths []string) error {
+	if err := executil.ExecPipe("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if filepath.Dir(p) == systemdSocketDropInDir {
+			if err := executil.ExecPipe("systemctl", "restart", "docker.socket"); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return executil.ExecPipe("systemctl", "restart", "docker")
+}
+
+// ReloadDockerUnits reloads the unit files, and restarts docker.socket if it
+// is running and its drop-ins are among the given paths.
+func (d systemdBaseDriver) ReloadDockerUnits(paths []string) error {
+	if err := executil.ExecPipe("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if filepath.Dir(p) == systemdSocketDropInDir {
+			return executil.ExecPipe("systemctl", "try-restart", "docker.socket")
+		}
+	}
+	return nil
+}
+
 func (d systemdBaseDriver) StartDocker() error {
 	return executil.ExecPipe("systemctl", "start", "docker")
 }
@@ -22,16 +104,300 @@ func (d systemdBaseDriver) StopDocker() error {
 	return executil.ExecPipe("systemctl", "stop", "docker")
 }
 
-// systemdUnitOverwriteDriver is for distros where we modify docker.service
-// file in-place.
-type systemdUnitOverwriteDriver struct{}
+func (d systemdBaseDriver) SetDockerAutostart(enabled bool) error {
+	if enabled {
+		return executil.ExecPipe("systemctl", "enable", "docker.service", "docker.socket")
+	}
+	return executil.ExecPipe("systemctl", "disable", "--now", "docker.service", "docker.socket")
+}
+
+// UpdatePruneSchedule installs a systemd timer running the command on the
+// given calendar schedule, or removes the timer if the schedule is empty.
+func (d systemdBaseDriver) UpdatePruneSchedule(schedule, cmd string) error {
+	timer := systemdPruneUnit + ".timer"
+	if schedule == "" {
+		if ok, err := util.PathExists(filepath.Join(systemdUnitDir, timer)); err != nil || !ok {
+			return err
+		}
+		if err := executil.ExecPipe("systemctl", "disable", "--now", timer); err != nil {
+			return err
+		}
+		for _, f := range []string{timer, systemdPruneUnit + ".service"} {
+			if _, err := removeDropIn(systemdUnitDir, f); err != nil {
+				return err
+			}
+		}
+		return executil.ExecPipe("systemctl", "daemon-reload")
+	}
+
+	units := []struct{ name, contents string }{
+		{systemdPruneUnit + ".service", fmt.Sprintf(`[Unit]
+Description=Prune unused docker objects
+After=docker.service
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Replace(cmd, "%", "%%", -1))},
+		{timer, fmt.Sprintf(`[Unit]
+Description=Prune unused docker objects %s
+
+[Timer]
+OnCalendar=%s
+RandomizedDelaySec=15min
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, schedule, schedule)},
+	}
+	var changed bool
+	for _, u := range units {
+		c, err := writeDropIn(systemdUnitDir, u.name, u.contents, 0644)
+		if err != nil {
+			return err
+		}
+		changed = changed || c
+	}
+	if changed {
+		if err := executil.ExecPipe("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+	}
+	if err := executil.ExecPipe("systemctl", "enable", timer); err != nil {
+		return err
+	}
+	if changed {
+		return executil.ExecPipe("systemctl", "restart", timer)
+	}
+	return executil.ExecPipe("systemctl", "start", timer)
+}
+
+func (d systemdBaseDriver) DaemonLogs(since time.Time) (string, error) {
+	out, err := executil.Exec("journalctl", "-u", "docker", "--no-pager", "-o", "cat",
+		"--since", since.Format("2006-01-02 15:04:05"))
+	return string(out), err
+}
+
+// UpdateDockerProxy writes the proxy environment variables of the docker
+// daemon to a drop-in, or removes the drop-in if no proxy is configured.
+func (d systemdBaseDriver) UpdateDockerProxy(p util.ProxyConfig) (bool, error) {
+	return updateProxyDropIn(systemdDropInDir, p)
+}
+
+// updateProxyDropIn writes the proxy environment variables of a service to a
+// drop-in in the given directory, or removes the drop-in if no proxy is
+// configured.
+func updateProxyDropIn(dir string, p util.ProxyConfig) (bool, error) {
+	env := p.Env()
+	if len(env) == 0 {
+		return removeDropIn(dir, systemdProxyDropInFile)
+	}
+	config := "[Service]\n"
+	for _, k := range proxyEnvKeys {
+		if v, ok := env[k]; ok {
+			config += fmt.Sprintf("Environment=\"%s=%s\"\n", k, systemdEscape(v))
+		}
+	}
+	// proxy urls may contain credentials
+	return writeDropIn(dir, systemdProxyDropInFile, config, 0600)
+}
+
+func (d systemdBaseDriver) SocketActivated() bool { return true }
+
+// UpdateDockerSocket configures the group and mode of the unix socket created
+// by docker.socket with a drop-in, or removes the drop-in if the defaults are
+// used. The socket is restarted (stopping the daemon) if the drop-in changes.
+func (d systemdBaseDriver) UpdateDockerSocket(group string, mode os.FileMode) (bool, error) {
+	var changed bool
+	var err error
+	if group == "" && mode == 0 {
+		changed, err = removeDropIn(systemdSocketDropInDir, systemdDropInFile)
+	} else {
+		config := "[Socket]\n"
+		if group != "" {
+			config += fmt.Sprintf("SocketGroup=%s\n", group)
+		}
+		if mode != 0 {
+			config += fmt.Sprintf("SocketMode=%04o\n", mode)
+		}
+		changed, err = writeDropIn(systemdSocketDropInDir, systemdDropInFile, config, 0644)
+	}
+	if err != nil || !changed {
+		return changed, err
+	}
+	if err := executil.ExecPipe("systemctl", "daemon-reload"); err != nil {
+		return false, err
+	}
+	return true, executil.ExecPipe("systemctl", "restart", "docker.socket")
+}
+
+// UpdateDockerMounts adds RequiresMountsFor= dependencies of the given paths
+// to docker.service, or removes the drop-in if no paths are given.
+func (d systemdBaseDriver) UpdateDockerMounts(paths []string) (bool, error) {
+	if len(paths) == 0 {
+		return removeDropIn(systemdDropInDir, systemdMountsDropInFile)
+	}
+	config := "[Unit]\n"
+	for _, p := range paths {
+		config += fmt.Sprintf("RequiresMountsFor=%s\n", strings.Replace(p, "%", "%%", -1))
+	}
+	return writeDropIn(systemdDropInDir, systemdMountsDropInFile, config, 0644)
+}
+
+// systemdEscape escapes the value for a quoted Environment= assignment,
+// including the '%' specifiers (e.g. in url-encoded credentials).
+func systemdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(v)
+}
+
+// systemdDropInDriver is for distros where we override the ExecStart of the
+// vendor docker.service with a drop-in unit.
+type systemdDropInDriver struct{}
+
+func (u systemdDropInDriver) UpdateDockerArgs(args string) (bool, error) {
+	if err := restoreVendorUnit(systemdVendorUnit); err != nil {
+		log.Printf("WARNING: could not restore %s: %v", systemdVendorUnit, err)
+	}
+
+	b, err := ioutil.ReadFile(dockerDaemonConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("error reading %s: %v", dockerDaemonConfig, err)
+	}
+	cfg, err := dockeropts.ParseDaemonConfig(b)
+	if err != nil {
+		return false, fmt.Errorf("error parsing %s: %v", dockerDaemonConfig, err)
+	}
+	cmd, err := vendorDaemonCommand(systemdVendorUnit, strings.Fields(args), cfg)
+	if err != nil {
+		return false, err
+	}
 
-func (u systemdUnitOverwriteDriver) UpdateDockerArgs(args string) (bool, error) {
-	const cfg = "/lib/systemd/system/docker.service"
-	e := dockeropts.SystemdUnitEditor{}
-	return rewriteOpts(e, cfg, args)
+	// empty ExecStart= clears the ExecStart of the vendor unit
+	config := fmt.Sprintf(`[Service]
+ExecStart=
+ExecStart=%s
+`, strings.TrimSpace(cmd+" "+args))
+	return writeDropIn(systemdDropInDir, systemdDropInFile, config, 0644)
 }
 
-func (u systemdUnitOverwriteDriver) BaseOpts() []string {
+func (u systemdDropInDriver) BaseOpts() []string {
 	return []string{"-H=fd://"}
 }
+
+// vendorDaemonCommand returns the command starting the docker daemon in the
+// vendor unit file with the vendor arguments not overridden by the given args
+// or daemon.json configuration (such as --containerd of docker-ce), so that
+// the drop-in starts the daemon the same way. If the vendor unit does not
+// exist, the default path is used.
+func vendorDaemonCommand(path string, args []string, cfg dockeropts.DaemonConfig) (string, error) {
+	const defaultCmd = "/usr/bin/dockerd"
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultCmd, nil
+		}
+		return "", fmt.Errorf("error reading %s: %v", path, err)
+	}
+	u, err := dockeropts.ParseUnit(string(b))
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	e := u.ServiceExecStart()
+	if e == nil {
+		return defaultCmd, nil
+	}
+	if inPlaceEditRegexp.Match(b) {
+		// arguments of the older versions of the extension
+		return dockeropts.DaemonCommand(e.Value), nil
+	}
+	cmd := append([]string{dockeropts.DaemonCommand(e.Value)}, dockeropts.DaemonArgs(e.Value, args, cfg)...)
+	return strings.Join(cmd, " "), nil
+}
+
+// restoreVendorUnit reinstalls the package owning the vendor unit file if the
+// file is modified in-place by the older versions of the extension. The
+// in-place modifications are harmless as the drop-in overrides ExecStart, so
+// it is fine if this fails (e.g. if the package repository is unreachable).
+func restoreVendorUnit(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if !inPlaceEditRegexp.Match(b) {
+		return nil
+	}
+
+	log.Printf("%s is modified by an older version of the extension, reinstalling its package", path)
+	if _, err := executil.Exec("dpkg-query", "--version"); err == nil {
+		out, err := executil.Exec("dpkg-query", "-S", path)
+		if err != nil {
+			return fmt.Errorf("cannot find package of %s: %v", path, err)
+		}
+		pkg := strings.SplitN(string(out), ":", 2)[0]
+		return executil.ExecPipe("apt-get", "install", "-qqy", "--reinstall", pkg)
+	}
+	out, err := executil.Exec("rpm", "-qf", "--queryformat", "%{NAME}", path)
+	if err != nil {
+		return fmt.Errorf("cannot find package of %s: %v", path, err)
+	}
+	return executil.ExecPipe("yum", "-y", "-q", "reinstall", strings.TrimSpace(string(out)))
+}
+
+// writeDropIn saves the systemd drop-in with given contents to the specified
+// drop-in directory (creating it if not exists). If the drop-in already has
+// the given contents, it is not written and this returns false.
+func writeDropIn(dir, name, contents string, perm os.FileMode) (bool, error) {
+	filePath := filepath.Join(dir, name)
+
+	// check if drop-in file exists and needs an update
+	if ok, _ := util.PathExists(filePath); ok {
+		existing, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return false, fmt.Errorf("error reading %s: %v", filePath, err)
+		}
+
+		// no need to update config or restart service if goal config is already there
+		if string(existing) == contents {
+			return false, nil
+		}
+	}
+
+	if err := backup.Save(filePath); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("error creating %s dir: %v", dir, err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(contents), perm); err != nil {
+		return false, fmt.Errorf("error writing %s: %v", filePath, err)
+	}
+	// WriteFile does not change the mode of existing files
+	if err := os.Chmod(filePath, perm); err != nil {
+		return false, fmt.Errorf("error changing mode of %s: %v", filePath, err)
+	}
+	log.Printf("Written systemd drop-in %s to disk.", filePath)
+	return true, nil
+}
+
+// removeDropIn deletes the systemd drop-in if it exists. Returns false if
+// the drop-in does not exist.
+func removeDropIn(dir, name string) (bool, error) {
+	filePath := filepath.Join(dir, name)
+	if ok, err := util.PathExists(filePath); err != nil {
+		return false, err
+	} else if !ok {
+		return false, nil
+	}
+	if err := backup.Save(filePath); err != nil {
+		return false, err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return false, fmt.Errorf("error removing %s: %v", filePath, err)
+	}
+	log.Printf("Removed systemd drop-in %s.", filePath)
+	return true, nil
+}
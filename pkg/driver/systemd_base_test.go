@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+)
+
+// dockerCEVendorUnit is the docker.service of the docker-ce package.
+const dockerCEVendorUnit = `[Unit]
+Description=Docker Application Container Engine
+Documentation=https://docs.docker.com
+After=network-online.target docker.socket firewalld.service containerd.service time-set.target
+Wants=network-online.target containerd.service
+Requires=docker.socket
+
+[Service]
+Type=notify
+# the default is not to use systemd for cgroups because the delegate issues still
+# exists and systemd currently does not support the cgroup feature set required
+# for containers run by docker
+ExecStart=/usr/bin/dockerd -H fd:// --containerd=/run/containerd/containerd.sock
+ExecReload=/bin/kill -s HUP $MAINPID
+TimeoutStartSec=0
+RestartSec=2
+Restart=always
+
+# Note that StartLimit* options were moved from "Service" to "Unit" in systemd 229.
+# Both the old, and new location are accepted by systemd 229 and up, so using the old location
+# to make them work for either version of systemd.
+StartLimitBurst=3
+
+# Note that StartLimitInterval was renamed to StartLimitIntervalSec in systemd 230.
+# Both the old, and new name are accepted for a unit of type=notify, so using the old name
+# to make them work for either version of systemd.
+StartLimitInterval=60s
+
+# Having non-zero Limit*s causes performance problems due to accounting overhead
+# in the kernel. We recommend using cgroups to do container-local accounting.
+LimitNPROC=infinity
+LimitCORE=infinity
+
+# Comment TasksMax if your systemd version does not support it.
+# Only systemd 226 and above support this option.
+TasksMax=infinity
+
+# set delegate yes so that systemd does not reset the cgroups of docker containers
+Delegate=yes
+
+# kill only the docker process, not all processes in the cgroup
+KillMode=process
+OOMScoreAdjust=-500
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func Test_vendorDaemonCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "docker.service")
+
+	if cmd, err := vendorDaemonCommand(path, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if cmd != "/usr/bin/dockerd" {
+		t.Fatalf("got %q for missing vendor unit", cmd)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(dockerCEVendorUnit), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-H=fd://", "-H=tcp://0.0.0.0:2376", "--tlsverify"}
+	for _, c := range []struct {
+		cfg      dockeropts.DaemonConfig
+		expected string
+	}{
+		{nil, "/usr/bin/dockerd --containerd=/run/containerd/containerd.sock"},
+		{dockeropts.DaemonConfig{"log-driver": "json-file"}, "/usr/bin/dockerd --containerd=/run/containerd/containerd.sock"},
+		// configured in daemon.json instead
+		{dockeropts.DaemonConfig{"containerd": "/run/containerd/other.sock"}, "/usr/bin/dockerd"},
+	} {
+		cmd, err := vendorDaemonCommand(path, args, c.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd != c.expected {
+			t.Fatalf("got %q for %v, expected: %q", cmd, c.cfg, c.expected)
+		}
+	}
+
+	// in-place edits of the older versions of the extension are not preserved
+	edited := "[Service]\nExecStart=/usr/bin/dockerd -H=fd:// --tlsverify --tlscacert=/etc/docker/ca.pem\n"
+	if err := ioutil.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cmd, err := vendorDaemonCommand(path, args, nil); err != nil {
+		t.Fatal(err)
+	} else if cmd != "/usr/bin/dockerd" {
+		t.Fatalf("got %q for in-place edited vendor unit", cmd)
+	}
+}
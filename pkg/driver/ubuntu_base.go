@@ -1,19 +1,222 @@
 package driver
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/util"
+)
+
+const (
+	// aptPinFile pins the docker engine packages to the version specified in
+	// the extension settings.
+	aptPinFile = "/etc/apt/preferences.d/docker-extension"
+
+	// aptKeyring is the keyring the docker-ce package repository is signed with.
+	aptKeyring = "/etc/apt/keyrings/docker-extension.gpg"
+
+	// aptSourcesFile configures the docker-ce package repository.
+	aptSourcesFile = "/etc/apt/sources.list.d/docker-extension.list"
 )
 
-type ubuntuBaseDriver struct{}
+type ubuntuBaseDriver struct {
+	codename string // e.g. 'xenial'
+}
 
-func (u ubuntuBaseDriver) InstallDocker(installCmd string) error {
-	return executil.ExecPipe("/bin/sh", "-c", installCmd)
+// enginePackages returns the docker engine packages installed from the source.
+func (u ubuntuBaseDriver) enginePackages(src PackageSource) []string {
+	if src.Type == SourceDistro {
+		return []string{"docker.io"}
+	}
+	return []string{"docker-ce", "docker-ce-cli"}
 }
 
-func (u ubuntuBaseDriver) UninstallDocker() error {
-	if err := executil.ExecPipe("apt-get", "-qqy", "purge", "docker-engine"); err != nil {
+func (u ubuntuBaseDriver) InstallDocker(src PackageSource) error {
+	if src.Type != SourceDistro {
+		// prerequisites for https package repositories signed with a key
+		if err := executil.ExecPipe("apt-get", "update", "-qq"); err != nil {
+			return err
+		}
+		if err := executil.ExecPipe("apt-get", "install", "-qqy", "apt-transport-https", "ca-certificates", "gnupg"); err != nil {
+			return err
+		}
+	}
+	if err := u.configureRepo(src); err != nil {
+		return fmt.Errorf("error configuring package repository: %v", err)
+	}
+	if err := executil.ExecPipe("apt-get", "update", "-qq"); err != nil {
 		return err
 	}
+	return executil.ExecPipe("apt-get", append([]string{"install", "-qqy"}, u.enginePackages(src)...)...)
+}
+
+func (u ubuntuBaseDriver) InstallRootlessPackages(src PackageSource) error {
+	if src.Type == SourceDistro {
+		return errors.New("rootless docker requires docker-ce packages")
+	}
+	return executil.ExecPipe("apt-get", "install", "-qqy", "uidmap", "dbus-user-session", "docker-ce-rootless-extras")
+}
+
+// configureRepo adds the docker-ce package repository signed with its key
+// to apt sources, or removes it if distro packages are used.
+func (u ubuntuBaseDriver) configureRepo(src PackageSource) error {
+	files := []string{aptKeyring, aptSourcesFile}
+	for _, f := range files {
+		if err := backup.Save(f); err != nil {
+			return err
+		}
+	}
+	if src.Type == SourceDistro {
+		for _, f := range files {
+			if err := os.RemoveAll(f); err != nil {
+				return fmt.Errorf("error removing %s: %v", f, err)
+			}
+		}
+		return nil
+	}
+
+	if u.codename == "" {
+		return fmt.Errorf("cannot determine the distro codename")
+	}
+	repo := src.repoURL("ubuntu")
+	key, err := downloadRepoKey(repo, aptKeyFingerprint)
+	if err != nil {
+		return err
+	}
+	if !aptModern() {
+		// the key is trusted for all repositories, as it is not removed
+		// from the apt keyring when the repository is removed
+		log.Printf("apt does not support signed-by, adding the repository key to apt-key")
+		if _, err := executil.ExecWithStdin(ioutil.NopCloser(bytes.NewReader(key)), "apt-key", "add", "-"); err != nil {
+			return fmt.Errorf("error adding repository key: %v", err)
+		}
+		sources := fmt.Sprintf("deb [arch=amd64] %s %s stable\n", repo, u.codename)
+		if err := ioutil.WriteFile(aptSourcesFile, []byte(sources), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", aptSourcesFile, err)
+		}
+		return nil
+	}
+	keyring, err := executil.ExecWithStdin(ioutil.NopCloser(bytes.NewReader(key)), "gpg", "--dearmor")
+	if err != nil {
+		return fmt.Errorf("error converting repository key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(aptKeyring), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(aptKeyring), err)
+	}
+	if err := ioutil.WriteFile(aptKeyring, keyring, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", aptKeyring, err)
+	}
+
+	sources := fmt.Sprintf("deb [arch=amd64 signed-by=%s] %s %s stable\n", aptKeyring, repo, u.codename)
+	if err := ioutil.WriteFile(aptSourcesFile, []byte(sources), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", aptSourcesFile, err)
+	}
+	return nil
+}
+
+// aptModern returns whether the installed apt is 1.1 or later (i.e. not on
+// trusty), which supports the signed-by option of the sources and the
+// --allow-downgrades flag.
+func aptModern() bool {
+	out, err := executil.Exec("dpkg-query", "-W", "-f=${Version}", "apt")
+	if err != nil {
+		return false
+	}
+	_, err = executil.Exec("dpkg", "--compare-versions", strings.TrimSpace(string(out)), "ge", "1.1")
+	return err == nil
+}
+
+func (u ubuntuBaseDriver) InstallDockerPackages(paths []string) error {
+	return executil.ExecPipe("dpkg", append([]string{"-i"}, paths...)...)
+}
+
+func (u ubuntuBaseDriver) DockerVersion() (string, error) {
+	for _, pkg := range []string{"docker-ce", "docker.io"} {
+		out, err := executil.Exec("dpkg-query", "-W", "-f=${Version}", pkg)
+		if err == nil && len(bytes.TrimSpace(out)) > 0 {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	return "", nil // not installed
+}
+
+func (u ubuntuBaseDriver) InstallDockerVersion(src PackageSource, version string) error {
+	if err := executil.ExecPipe("apt-get", "update", "-qq"); err != nil {
+		return err
+	}
+	engine := u.enginePackages(src)
+	out, err := executil.Exec("apt-cache", "madison", engine[0])
+	if err != nil {
+		return fmt.Errorf("cannot list available %s versions: %v", engine[0], err)
+	}
+	pv, ok := pickVersion(parseColumn(string(out), "|", 1), version)
+	if !ok {
+		return fmt.Errorf("%s version %s is not available in the package repository", engine[0], version)
+	}
+
+	pkgs := []string{engine[0]}
+	for _, p := range engine[1:] {
+		if out, err := executil.Exec("apt-cache", "madison", p); err == nil {
+			if _, ok := pickVersion(parseColumn(string(out), "|", 1), pv); ok {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+
+	pin := fmt.Sprintf("Package: %s\nPin: version %s\nPin-Priority: 1001\n", strings.Join(pkgs, " "), pv)
+	if err := backup.Save(aptPinFile); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(aptPinFile, []byte(pin), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", aptPinFile, err)
+	}
+
+	// older apt allows downgrades only with --force-yes
+	args := []string{"install", "-qqy", "--force-yes"}
+	if aptModern() {
+		args = []string{"install", "-qqy", "--allow-downgrades"}
+	}
+	for _, p := range pkgs {
+		args = append(args, fmt.Sprintf("%s=%s", p, pv))
+	}
+	return executil.ExecPipe("apt-get", args...)
+}
+
+func (u ubuntuBaseDriver) UnpinDockerVersion() error {
+	if ok, err := util.PathExists(aptPinFile); err != nil || !ok {
+		return err
+	}
+	if err := backup.Save(aptPinFile); err != nil {
+		return err
+	}
+	return os.Remove(aptPinFile)
+}
+
+// UninstallDocker purges the engine packages of any package source (and the
+// legacy docker-engine package) that are installed, and their dependencies
+// such as containerd.io.
+func (u ubuntuBaseDriver) UninstallDocker() error {
+	candidates := append(u.enginePackages(PackageSource{Type: SourceDockerCE}),
+		append(u.enginePackages(PackageSource{Type: SourceDistro}), "docker-engine")...)
+	var pkgs []string
+	for _, p := range candidates {
+		if out, err := executil.Exec("dpkg-query", "-W", "-f=${Status}", p); err == nil && len(bytes.TrimSpace(out)) > 0 {
+			pkgs = append(pkgs, p)
+		}
+	}
+	if len(pkgs) > 0 {
+		if err := executil.ExecPipe("apt-get", append([]string{"-qqy", "purge"}, pkgs...)...); err != nil {
+			return err
+		}
+	}
 	return executil.ExecPipe("apt-get", "-qqy", "autoremove")
 }
 
@@ -3,5 +3,5 @@ package driver
 type UbuntuSystemdDriver struct {
 	ubuntuBaseDriver
 	systemdBaseDriver
-	systemdUnitOverwriteDriver
+	systemdDropInDriver
 }
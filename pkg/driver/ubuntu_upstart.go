@@ -1,9 +1,16 @@
 package driver
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/util"
 )
 
+const upstartCfgPath = "/etc/default/docker"
+
 type UbuntuUpstartDriver struct {
 	ubuntuBaseDriver
 	upstartBaseDriver
@@ -14,7 +21,38 @@ func (u UbuntuUpstartDriver) BaseOpts() []string {
 }
 
 func (u UbuntuUpstartDriver) UpdateDockerArgs(args string) (bool, error) {
-	const cfgPath = "/etc/default/docker"
 	e := dockeropts.UpstartCfgEditor{}
-	return rewriteOpts(e, cfgPath, args)
+	return rewriteOpts(e, upstartCfgPath, args)
+}
+
+// UpdateDockerProxy exports the proxy environment variables in the docker
+// configuration file sourced by the init script.
+func (u UbuntuUpstartDriver) UpdateDockerProxy(p util.ProxyConfig) (bool, error) {
+	e := dockeropts.UpstartEnvEditor{Keys: proxyEnvKeys}
+	changed, err := rewriteFile(upstartCfgPath, func(in string) (string, error) {
+		return e.ChangeEnv(in, p.Env())
+	})
+	if err != nil || !p.IsSet() {
+		return changed, err
+	}
+	// proxy urls may contain credentials
+	if err := os.Chmod(upstartCfgPath, 0600); err != nil {
+		return false, fmt.Errorf("error changing mode of %s: %v", upstartCfgPath, err)
+	}
+	return changed, nil
+}
+
+// UpdateDockerMounts is a noop as upstart starts docker after the local
+// file systems are mounted.
+func (u UbuntuUpstartDriver) UpdateDockerMounts(paths []string) (bool, error) {
+	return false, nil
+}
+
+// UpdateDockerSocket returns error if a socket group or mode is specified, as
+// the daemon creates the socket on upstart (see SocketActivated).
+func (u UbuntuUpstartDriver) UpdateDockerSocket(group string, mode os.FileMode) (bool, error) {
+	if group != "" || mode != 0 {
+		return false, errors.New("unix socket group and mode are not supported with upstart")
+	}
+	return false, nil
 }
@@ -1,22 +1,135 @@
 package driver
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/util"
+)
+
+const (
+	upstartDockerLog = "/var/log/upstart/docker.log"
+
+	// pruneCronFile is the cron job pruning the unused docker objects.
+	pruneCronFile = "/etc/cron.d/docker-extension-prune"
 )
 
+// upstartLogOffset is the size of the upstart log of the docker daemon when
+// it is last (re)started by the running process, so that only the lines
+// written after that are returned by DaemonLogs.
+var upstartLogOffset int64
+
 type upstartBaseDriver struct{}
 
 func (d upstartBaseDriver) RestartDocker() error {
 	if err := executil.ExecPipe("update-rc.d", "docker", "defaults"); err != nil {
 		return err
 	}
+	upstartLogOffset = fileSize(upstartDockerLog)
 	return executil.ExecPipe("service", "docker", "restart")
 }
 
+// RestartDockerUnits restarts the docker daemon, which is the only service
+// configured by the extension on upstart.
+func (d upstartBaseDriver) RestartDockerUnits(paths []string) error {
+	return d.RestartDocker()
+}
+
+// ReloadDockerUnits is a noop as the docker configuration is read by the
+// init script upon start.
+func (d upstartBaseDriver) ReloadDockerUnits(paths []string) error {
+	return nil
+}
+
 func (d upstartBaseDriver) StartDocker() error {
+	upstartLogOffset = fileSize(upstartDockerLog)
 	return executil.ExecPipe("service", "docker", "start")
 }
 
 func (d upstartBaseDriver) StopDocker() error {
 	return executil.ExecPipe("service", "docker", "stop")
 }
+
+func (d upstartBaseDriver) SocketActivated() bool { return false }
+
+// SetDockerAutostart only supports enabling, which is done by RestartDocker,
+// as running the engine as a non-root user requires systemd.
+func (d upstartBaseDriver) SetDockerAutostart(enabled bool) error {
+	if !enabled {
+		return errors.New("rootless docker requires systemd and is not supported on upstart")
+	}
+	return nil
+}
+
+// UpdatePruneSchedule installs a cron job running the command on the given
+// schedule, or removes the job if the schedule is empty.
+func (d upstartBaseDriver) UpdatePruneSchedule(schedule, cmd string) error {
+	if schedule == "" {
+		if ok, err := util.PathExists(pruneCronFile); err != nil || !ok {
+			return err
+		}
+		if err := backup.Save(pruneCronFile); err != nil {
+			return err
+		}
+		if err := os.Remove(pruneCronFile); err != nil {
+			return fmt.Errorf("error removing %s: %v", pruneCronFile, err)
+		}
+		return nil
+	}
+	job := fmt.Sprintf("# Prunes unused docker objects, installed by the docker extension\n@%s root %s >/dev/null 2>&1\n", schedule, cmd)
+	if b, err := ioutil.ReadFile(pruneCronFile); err == nil && string(b) == job {
+		return nil
+	}
+	if err := backup.Save(pruneCronFile); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(pruneCronFile, []byte(job), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", pruneCronFile, err)
+	}
+	return nil
+}
+
+// DaemonLogs returns the upstart log of the docker daemon written since it is
+// last (re)started, if it is modified after the given time, since the log
+// lines are not timestamped by upstart.
+func (d upstartBaseDriver) DaemonLogs(since time.Time) (string, error) {
+	return logTail(upstartDockerLog, upstartLogOffset, since)
+}
+
+// logTail returns the contents of the log file after the given offset if it
+// is modified after the given time. The whole file is returned if it is
+// shorter than the offset (i.e. rotated since).
+func logTail(path string, offset int64, since time.Time) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if fi.ModTime().Before(since) {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if offset <= int64(len(b)) {
+		b = b[offset:]
+	}
+	return string(b), nil
+}
+
+// fileSize returns the size of the file, or 0 if it cannot be read.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
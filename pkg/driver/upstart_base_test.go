@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_logTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "docker.log")
+
+	if out, err := logTail(path, 0, time.Time{}); err != nil {
+		t.Fatal(err)
+	} else if out != "" {
+		t.Fatalf("expected no logs for missing file, got: %q", out)
+	}
+
+	old := "old error\n"
+	if err := ioutil.WriteFile(path, []byte(old+"new error\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		offset   int64
+		since    time.Time
+		expected string
+	}{
+		{int64(len(old)), time.Time{}, "new error\n"},
+		{0, time.Time{}, old + "new error\n"},
+		{1024, time.Time{}, old + "new error\n"}, // rotated
+		{0, time.Now().Add(time.Hour), ""},       // not modified since
+	} {
+		out, err := logTail(path, c.offset, c.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != c.expected {
+			t.Fatalf("got wrong logs for offset %d: %q, expected: %q", c.offset, out, c.expected)
+		}
+	}
+}
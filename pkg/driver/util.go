@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/Azure/azure-docker-extension/pkg/backup"
 	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
 	"github.com/Azure/azure-docker-extension/pkg/util"
 )
@@ -12,12 +13,20 @@ import (
 // (if not exists, it creates the cfg file and its directory) with specified args.
 // If nothing is changed, this will return false.
 func rewriteOpts(e dockeropts.Editor, cfgFile string, args string) (restartNeeded bool, err error) {
+	return rewriteFile(cfgFile, func(in string) (string, error) {
+		return e.ChangeOpts(in, args)
+	})
+}
+
+// rewriteFile modifies the existing cfgFile with the given edit function.
+// If nothing is changed, this will return false.
+func rewriteFile(cfgFile string, edit func(in string) (string, error)) (changed bool, err error) {
 	in, err := ioutil.ReadFile(cfgFile)
 	if err != nil {
 		return false, fmt.Errorf("error reading %s: %v", cfgFile, err)
 	}
 
-	out, err := e.ChangeOpts(string(in), args)
+	out, err := edit(string(in))
 	if err != nil {
 		return false, fmt.Errorf("error updating settings at %s: %v", cfgFile, err)
 	}
@@ -35,6 +44,9 @@ func rewriteOpts(e dockeropts.Editor, cfgFile string, args string) (restartNeede
 		}
 	}
 
+	if err := backup.Save(cfgFile); err != nil {
+		return false, err
+	}
 	if err := ioutil.WriteFile(cfgFile, []byte(out), 0644); err != nil {
 		return false, fmt.Errorf("error writing to %s: %v", cfgFile, err)
 	}
@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"strings"
+)
+
+// stripEpoch removes the epoch prefix (e.g. '5:') from a package version.
+func stripEpoch(pkgVersion string) string {
+	if i := strings.Index(pkgVersion, ":"); i >= 0 {
+		return pkgVersion[i+1:]
+	}
+	return pkgVersion
+}
+
+// VersionMatches reports whether the package version (such as
+// '5:20.10.7~3-0~ubuntu-focal' or '3:20.10.7-3.el7') is the version of docker
+// engine specified by the user (such as '20.10.7') or is exactly the specified
+// package version.
+func VersionMatches(pkgVersion, want string) bool {
+	if pkgVersion == "" || want == "" {
+		return false
+	}
+	v := stripEpoch(pkgVersion)
+	return pkgVersion == want || v == want ||
+		strings.HasPrefix(v, want+"~") || strings.HasPrefix(v, want+"-")
+}
+
+// pickVersion returns the first of the available package versions (ordered
+// from the newest to the oldest) matching the specified version.
+func pickVersion(available []string, want string) (string, bool) {
+	for _, v := range available {
+		if VersionMatches(v, want) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// parseColumn returns the specified whitespace- or separator-separated column
+// of each non-empty line in out.
+func parseColumn(out string, sep string, col int) []string {
+	var l []string
+	for _, line := range strings.Split(out, "\n") {
+		var f []string
+		if sep == "" {
+			f = strings.Fields(line)
+		} else {
+			f = strings.Split(line, sep)
+		}
+		if len(f) > col {
+			l = append(l, strings.TrimSpace(f[col]))
+		}
+	}
+	return l
+}
@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_VersionMatches(t *testing.T) {
+	for _, c := range []struct {
+		pkg, want string
+		out       bool
+	}{
+		{"5:20.10.7~3-0~ubuntu-focal", "20.10.7", true},
+		{"5:20.10.7~3-0~ubuntu-focal", "5:20.10.7~3-0~ubuntu-focal", true},
+		{"5:20.10.17~3-0~ubuntu-focal", "20.10.1", false},
+		{"3:20.10.7-3.el7", "20.10.7", true},
+		{"3:20.10.7-3.el7", "20.10.7-3.el7", true},
+		{"17.03.1~ce-0~ubuntu-xenial", "17.03.1", true},
+		{"", "20.10.7", false},
+		{"3:20.10.7-3.el7", "", false},
+	} {
+		if out := VersionMatches(c.pkg, c.want); out != c.out {
+			t.Fatalf("got %v for (%q, %q), expected: %v", out, c.pkg, c.want, c.out)
+		}
+	}
+}
+
+func Test_pickVersion(t *testing.T) {
+	madison := ` docker-ce | 5:20.10.8~3-0~ubuntu-focal | https://download.docker.com/linux/ubuntu focal/stable amd64 Packages
+ docker-ce | 5:20.10.7~3-0~ubuntu-focal | https://download.docker.com/linux/ubuntu focal/stable amd64 Packages
+`
+	l := parseColumn(madison, "|", 1)
+	if expected := []string{"5:20.10.8~3-0~ubuntu-focal", "5:20.10.7~3-0~ubuntu-focal"}; !reflect.DeepEqual(l, expected) {
+		t.Fatalf("got wrong versions: %v, expected: %v", l, expected)
+	}
+	if v, ok := pickVersion(l, "20.10.7"); !ok || v != l[1] {
+		t.Fatalf("got wrong version: %q", v)
+	}
+	if _, ok := pickVersion(l, "19.03.1"); ok {
+		t.Fatal("version should not be found")
+	}
+}
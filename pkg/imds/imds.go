@@ -0,0 +1,99 @@
+// Package imds queries the Azure Instance Metadata Service of the VM.
+package imds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Endpoint is the base URL of the instance metadata service.
+var Endpoint = "http://169.254.169.254"
+
+const apiVersion = "2021-02-01"
+
+// client connects to the instance metadata service directly, as it must not
+// be accessed through a proxy.
+var client = &http.Client{
+	Transport: &http.Transport{Proxy: nil},
+	Timeout:   5 * time.Second,
+}
+
+// Network is the network metadata of the VM.
+type Network struct {
+	Interface []struct {
+		IPv4 struct {
+			IPAddress []ipAddress `json:"ipAddress"`
+		} `json:"ipv4"`
+		IPv6 struct {
+			IPAddress []ipAddress `json:"ipAddress"`
+		} `json:"ipv6"`
+	} `json:"interface"`
+}
+
+type ipAddress struct {
+	PrivateIPAddress string `json:"privateIpAddress"`
+	PublicIPAddress  string `json:"publicIpAddress"`
+}
+
+// GetNetwork returns the network metadata of the VM.
+func GetNetwork() (*Network, error) {
+	var n Network
+	if err := get("/metadata/instance/network", &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// PublicIPs returns the public IP addresses of the network interfaces.
+func (n *Network) PublicIPs() []string {
+	var out []string
+	for _, i := range n.Interface {
+		for _, a := range append(i.IPv4.IPAddress, i.IPv6.IPAddress...) {
+			if a.PublicIPAddress != "" {
+				out = append(out, a.PublicIPAddress)
+			}
+		}
+	}
+	return out
+}
+
+// PrivateIPs returns the private IP addresses of the network interfaces.
+func (n *Network) PrivateIPs() []string {
+	var out []string
+	for _, i := range n.Interface {
+		for _, a := range append(i.IPv4.IPAddress, i.IPv6.IPAddress...) {
+			if a.PrivateIPAddress != "" {
+				out = append(out, a.PrivateIPAddress)
+			}
+		}
+	}
+	return out
+}
+
+func get(path string, v interface{}) error {
+	url := fmt.Sprintf("%s%s?api-version=%s", Endpoint, path, apiVersion)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("imds: error querying %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("imds: response status code from %s: %s", path, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("imds: error reading response from %s: %v", path, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("imds: error parsing response from %s: %v", path, err)
+	}
+	return nil
+}
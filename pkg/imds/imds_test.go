@@ -0,0 +1,50 @@
+package imds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const testNetwork = `{
+  "interface": [{
+    "ipv4": {
+      "ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": "52.1.2.3"}],
+      "subnet": [{"address": "10.0.0.0", "prefix": "24"}]
+    },
+    "ipv6": {"ipAddress": []},
+    "macAddress": "000D3A000000"
+  }, {
+    "ipv4": {"ipAddress": [{"privateIpAddress": "10.0.1.4", "publicIpAddress": ""}]},
+    "ipv6": {"ipAddress": []}
+  }]
+}`
+
+func Test_GetNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing Metadata header", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path != "/metadata/instance/network" || r.URL.Query().Get("api-version") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testNetwork))
+	}))
+	defer srv.Close()
+	defer func(e string) { Endpoint = e }(Endpoint)
+	Endpoint = srv.URL
+
+	n, err := GetNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := n.PublicIPs(), []string{"52.1.2.3"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got public IPs: %v, expected: %v", got, expected)
+	}
+	if got, expected := n.PrivateIPs(), []string{"10.0.0.4", "10.0.1.4"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got private IPs: %v, expected: %v", got, expected)
+	}
+}
@@ -0,0 +1,115 @@
+// Package pkgbundle reads a directory of packages (and binaries) to be
+// installed without network access, and verifies them against a manifest
+// of checksums in sha256sum(1) format.
+package pkgbundle
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFile is the name of the checksum manifest in the bundle directory.
+const ManifestFile = "SHA256SUMS"
+
+// Bundle is a directory of files listed in the checksum manifest.
+type Bundle struct {
+	Dir   string
+	files map[string]string // file name to sha256 checksum
+}
+
+// Load parses the manifest in the given directory and verifies the checksums
+// of all files listed in it. Files not listed in the manifest are ignored.
+func Load(dir string) (*Bundle, error) {
+	m := filepath.Join(dir, ManifestFile)
+	f, err := os.Open(m)
+	if err != nil {
+		return nil, fmt.Errorf("pkgbundle: cannot open manifest: %v", err)
+	}
+	defer f.Close()
+
+	files, err := parseManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("pkgbundle: cannot parse %s: %v", m, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("pkgbundle: no files listed in %s", m)
+	}
+
+	b := &Bundle{Dir: dir, files: files}
+	for name, sum := range files {
+		if err := verify(filepath.Join(dir, name), sum); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// parseManifest parses lines in '<sha256>  <file name>' format (as written by
+// sha256sum, file name may be prefixed with '*' in binary mode).
+func parseManifest(r io.Reader) (map[string]string, error) {
+	m := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		p := strings.Fields(l)
+		if len(p) != 2 || len(p[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("unexpected manifest line: %q", l)
+		}
+		name := strings.TrimPrefix(p[1], "*")
+		if name != filepath.Base(name) {
+			return nil, fmt.Errorf("file %q is not in the bundle directory", name)
+		}
+		m[name] = strings.ToLower(p[0])
+	}
+	return m, sc.Err()
+}
+
+func verify(path, sum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("pkgbundle: cannot open %s: %v", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("pkgbundle: cannot read %s: %v", path, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("pkgbundle: checksum mismatch for %s: expected %s, got %s", path, sum, got)
+	}
+	return nil
+}
+
+// Path returns the path of the file with given name in the bundle.
+func (b *Bundle) Path(name string) (string, bool) {
+	if _, ok := b.files[name]; !ok {
+		return "", false
+	}
+	return filepath.Join(b.Dir, name), true
+}
+
+// Paths returns the sorted paths of the files in the bundle with any of the
+// given extensions (such as '.deb').
+func (b *Bundle) Paths(exts ...string) []string {
+	var out []string
+	for name := range b.files {
+		for _, ext := range exts {
+			if filepath.Ext(name) == ext {
+				out = append(out, filepath.Join(b.Dir, name))
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
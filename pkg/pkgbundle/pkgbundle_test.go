@@ -0,0 +1,73 @@
+package pkgbundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func testBundle(t *testing.T, manifest string) string {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"docker-ce.deb":     "deb1",
+		"docker-ce-cli.deb": "deb2",
+		"docker-compose":    "bin",
+		"unlisted.deb":      "foo",
+		ManifestFile:        manifest,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_Load(t *testing.T) {
+	dir := testBundle(t, fmt.Sprintf("%s  docker-ce.deb\n%s *docker-ce-cli.deb\n\n%s  docker-compose\n",
+		sum("deb1"), sum("deb2"), sum("bin")))
+	defer os.RemoveAll(dir)
+
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "docker-ce-cli.deb"), filepath.Join(dir, "docker-ce.deb")}
+	if out := b.Paths(".deb", ".rpm"); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("got wrong paths: %v, expected: %v", out, expected)
+	}
+	if p, ok := b.Path("docker-compose"); !ok || p != filepath.Join(dir, "docker-compose") {
+		t.Fatalf("got wrong path: %s", p)
+	}
+	if _, ok := b.Path("unlisted.deb"); ok {
+		t.Fatal("unlisted file should not be in the bundle")
+	}
+}
+
+func Test_Load_Bad(t *testing.T) {
+	for _, manifest := range []string{
+		"",
+		fmt.Sprintf("%s  docker-ce.deb\n", sum("tampered")),
+		fmt.Sprintf("%s  missing.deb\n", sum("deb1")),
+		fmt.Sprintf("%s  ../docker-ce.deb\n", sum("deb1")),
+		"abc docker-ce.deb\n",
+	} {
+		dir := testBundle(t, manifest)
+		defer os.RemoveAll(dir)
+		if _, err := Load(dir); err == nil {
+			t.Fatalf("expected error for manifest %q", manifest)
+		}
+	}
+}
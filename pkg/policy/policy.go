@@ -0,0 +1,170 @@
+// Package policy checks the docker engine configuration for dangerous
+// settings and decides whether they are rejected or reported as warnings
+// according to a strictness level.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+)
+
+// Level is the strictness of the policy.
+type Level string
+
+const (
+	// Off disables the checks.
+	Off Level = "off"
+	// Warn reports all findings as warnings.
+	Warn Level = "warn"
+	// Standard rejects high severity findings and warns about the rest.
+	Standard Level = "standard"
+	// Strict rejects all findings.
+	Strict Level = "strict"
+
+	// DefaultLevel rejects the high severity findings, lower levels have to
+	// be chosen explicitly.
+	DefaultLevel = Standard
+)
+
+// ParseLevel parses the level name, empty string is the default level.
+func ParseLevel(s string) (Level, error) {
+	switch l := Level(s); l {
+	case "":
+		return DefaultLevel, nil
+	case Off, Warn, Standard, Strict:
+		return l, nil
+	}
+	return "", fmt.Errorf("invalid policy level %q, valid levels: %s, %s, %s, %s", s, Off, Warn, Standard, Strict)
+}
+
+// Severity of a finding.
+type Severity int
+
+const (
+	Medium Severity = iota
+	High
+)
+
+func (s Severity) String() string {
+	if s == High {
+		return "high"
+	}
+	return "medium"
+}
+
+// Finding is a dangerous setting found in the configuration.
+type Finding struct {
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s", f.Rule, f.Message)
+}
+
+// Input is the docker engine configuration to check.
+type Input struct {
+	Args   []string                // command line arguments of dockerd
+	Config dockeropts.DaemonConfig // effective daemon.json configuration
+	// PublishedPorts are the port mappings of the containers, in the
+	// docker-compose short syntax ([ip:]host:container or container).
+	PublishedPorts []string
+}
+
+// Check returns the dangerous settings found in the configuration.
+func Check(in Input) ([]Finding, error) {
+	var out []Finding
+	flags, _, err := dockeropts.ParseFlags(in.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid daemon options: %v", err)
+	}
+	cfg := dockeropts.MergeDaemonConfig(in.Config, flags, nil)
+
+	var tcp []string
+	for _, h := range stringList(cfg["hosts"]) {
+		if strings.HasPrefix(h, "tcp://") || (!strings.Contains(h, "://") && strings.Contains(h, ":")) {
+			tcp = append(tcp, h)
+		}
+	}
+	tls, tlsverify := cfg["tls"] == true, cfg["tlsverify"] == true
+	if len(tcp) > 0 && !tls && !tlsverify {
+		out = append(out, Finding{"unauthenticated-tcp", High,
+			fmt.Sprintf("engine listens on %s without TLS, anyone who can connect has root access to the VM", strings.Join(tcp, ", "))})
+	} else if len(tcp) > 0 && !tlsverify {
+		out = append(out, Finding{"tls-verify-disabled", High,
+			fmt.Sprintf("engine listens on %s with TLS but without verifying client certificates (tlsverify), anyone who can connect has root access to the VM", strings.Join(tcp, ", "))})
+	}
+
+	if r := stringList(cfg["insecure-registries"]); len(r) > 0 {
+		out = append(out, Finding{"insecure-registry", Medium,
+			fmt.Sprintf("images from %s are pulled without TLS verification", strings.Join(r, ", "))})
+	}
+
+	if cfg["icc"] != false {
+		bindIP, _ := cfg["ip"].(string)
+		if bindIP == "" || bindIP == "0.0.0.0" || bindIP == "::" {
+			if p := publicPorts(in.PublishedPorts); len(p) > 0 {
+				out = append(out, Finding{"icc-public-ports", Medium,
+					fmt.Sprintf("inter-container communication (icc) is enabled while ports %s are published on all interfaces, a compromised container can reach all other containers", strings.Join(p, ", "))})
+			}
+		}
+	}
+	return out, nil
+}
+
+// Evaluate splits the findings into the ones rejected and the ones reported
+// as warnings at the given level.
+func Evaluate(level Level, findings []Finding) (rejected, warnings []Finding) {
+	for _, f := range findings {
+		switch {
+		case level == Off:
+		case level == Strict, level == Standard && f.Severity == High:
+			rejected = append(rejected, f)
+		default:
+			warnings = append(warnings, f)
+		}
+	}
+	return
+}
+
+// publicPorts returns the port mappings that bind all interfaces of the host.
+func publicPorts(ports []string) []string {
+	var out []string
+	for _, p := range ports {
+		// [ip:]host:container, ip may be an IPv6 address in brackets
+		p = strings.SplitN(p, "/", 2)[0]
+		if i := strings.LastIndex(p, "]:"); i >= 0 {
+			ip := strings.Trim(p[:i+1], "[]")
+			if ip == "::" {
+				out = append(out, p)
+			}
+			continue
+		}
+		parts := strings.Split(p, ":")
+		if len(parts) < 3 || parts[0] == "" || parts[0] == "0.0.0.0" {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+)
+
+func rules(l []Finding) []string {
+	var out []string
+	for _, f := range l {
+		out = append(out, f.Rule)
+	}
+	return out
+}
+
+func Test_Check(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		in    Input
+		rules []string
+	}{
+		{"defaults", Input{Args: []string{"-H=fd://"}}, nil},
+		{"tcp without tls", Input{Args: []string{"-H=fd://", "-H=0.0.0.0:2375"}},
+			[]string{"unauthenticated-tcp"}},
+		{"tcp with tlsverify", Input{
+			Args:   []string{"-H=fd://", "-H=tcp://0.0.0.0:2376"},
+			Config: dockeropts.DaemonConfig{"tlsverify": true}}, nil},
+		{"tcp with tls only", Input{
+			Args:   []string{"-H=tcp://0.0.0.0:2376", "--tls"},
+			Config: dockeropts.DaemonConfig{}},
+			[]string{"tls-verify-disabled"}},
+		{"tlsverify disabled", Input{
+			Args:   []string{"-H=tcp://10.0.0.4:2376"},
+			Config: dockeropts.DaemonConfig{"tls": true, "tlsverify": false}},
+			[]string{"tls-verify-disabled"}},
+		{"insecure registry", Input{
+			Config: dockeropts.DaemonConfig{"insecure-registries": []interface{}{"registry:5000"}}},
+			[]string{"insecure-registry"}},
+		{"public ports", Input{PublishedPorts: []string{"80:2368", "127.0.0.1:11211:11211"}},
+			[]string{"icc-public-ports"}},
+		{"public ports without icc", Input{
+			Config:         dockeropts.DaemonConfig{"icc": false},
+			PublishedPorts: []string{"80:2368"}}, nil},
+		{"local ports", Input{PublishedPorts: []string{"127.0.0.1:11211:11211", "[::1]:80:80"}}, nil},
+	} {
+		findings, err := Check(c.in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := rules(findings); !reflect.DeepEqual(got, c.rules) {
+			t.Fatalf("%s: got %v, expected: %v", c.name, got, c.rules)
+		}
+	}
+
+	if _, err := Check(Input{Args: []string{"--tlsverify=maybe"}}); err == nil {
+		t.Fatal("expected error for invalid daemon options")
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	findings := []Finding{
+		{"unauthenticated-tcp", High, ""},
+		{"insecure-registry", Medium, ""},
+	}
+	for _, c := range []struct {
+		level              Level
+		rejected, warnings []string
+	}{
+		{Off, nil, nil},
+		{Warn, nil, []string{"unauthenticated-tcp", "insecure-registry"}},
+		{Standard, []string{"unauthenticated-tcp"}, []string{"insecure-registry"}},
+		{Strict, []string{"unauthenticated-tcp", "insecure-registry"}, nil},
+	} {
+		r, w := Evaluate(c.level, findings)
+		if !reflect.DeepEqual(rules(r), c.rejected) || !reflect.DeepEqual(rules(w), c.warnings) {
+			t.Fatalf("%s: got rejected=%v warnings=%v, expected: %v %v", c.level, rules(r), rules(w), c.rejected, c.warnings)
+		}
+	}
+}
+
+func Test_ParseLevel(t *testing.T) {
+	if l, err := ParseLevel(""); err != nil || l != Standard {
+		t.Fatalf("got %q %v, expected default level %q", l, err, Standard)
+	}
+	if _, err := ParseLevel("paranoid"); err == nil {
+		t.Fatal("expected error")
+	}
+}
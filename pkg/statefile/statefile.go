@@ -0,0 +1,54 @@
+// Package statefile contains helper methods that persist small pieces of
+// JSON-encoded handler state (such as what the extension has configured on
+// the host previously) across invocations of the extension handler.
+package statefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir is the directory the state files are saved in. It lives outside of the
+// extension directory so that the state survives extension updates.
+var Dir = "/var/lib/azure-docker-extension"
+
+// Get reads the state file with given name and unmarshals it into v. If the
+// state file does not exist, exists is false and v is not modified.
+func Get(name string, v interface{}) (exists bool, err error) {
+	b, err := ioutil.ReadFile(Path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return true, fmt.Errorf("statefile: cannot parse %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// Set saves v as the state file with given name.
+func Set(name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Errorf("statefile: cannot marshal %s: %v", name, err)
+	}
+	if err := os.MkdirAll(Dir, 0700); err != nil {
+		return fmt.Errorf("statefile: cannot create %s: %v", Dir, err)
+	}
+	return ioutil.WriteFile(Path(name), b, 0600)
+}
+
+// Delete removes the state file with given name, if exists.
+func Delete(name string) error {
+	return os.RemoveAll(Path(name))
+}
+
+// Path returns the path of the state file with given name.
+func Path(name string) string {
+	return filepath.Join(Dir, name+".json")
+}
@@ -0,0 +1,44 @@
+package statefile
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_GetSetDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Dir = dir
+
+	var v []string
+	if ok, err := Get("foo", &v); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("state should not exist")
+	}
+
+	in := []string{"a", "b"}
+	if err := Set("foo", in); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Get("foo", &v); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("state should exist")
+	}
+	if !reflect.DeepEqual(in, v) {
+		t.Fatalf("got wrong state: %v, expected: %v", v, in)
+	}
+
+	if err := Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Get("foo", &v); ok {
+		t.Fatal("state should be deleted")
+	}
+}
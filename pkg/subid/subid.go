@@ -0,0 +1,110 @@
+// Package subid manages the subordinate user and group ID ranges in
+// /etc/subuid and /etc/subgid, which are required by user namespaces.
+package subid
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+)
+
+const (
+	SubUIDFile = "/etc/subuid"
+	SubGIDFile = "/etc/subgid"
+
+	// DefaultCount is the size of the ranges allocated, which covers all
+	// the IDs of a container.
+	DefaultCount = 65536
+
+	// firstID is where allocated ranges start, above the IDs of the regular
+	// users (as useradd does).
+	firstID = 100000
+)
+
+// Range is a subordinate ID range of a user.
+type Range struct {
+	Name  string
+	Start int
+	Count int
+}
+
+// Parse parses the contents of a subuid/subgid file. Comments, blank and
+// malformed lines are skipped.
+func Parse(contents string) []Range {
+	var out []Range
+	for _, l := range strings.Split(contents, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		p := strings.Split(l, ":")
+		if len(p) != 3 {
+			continue
+		}
+		start, err1 := strconv.Atoi(p[1])
+		count, err2 := strconv.Atoi(p[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		out = append(out, Range{p[0], start, count})
+	}
+	return out
+}
+
+// Find returns the range of the given user (by name or uid), if exists.
+func Find(ranges []Range, names ...string) (Range, bool) {
+	for _, r := range ranges {
+		for _, n := range names {
+			if r.Name == n {
+				return r, true
+			}
+		}
+	}
+	return Range{}, false
+}
+
+// Allocate returns the start of a new range after the existing ranges, so
+// that it does not overlap with them.
+func Allocate(ranges []Range) int {
+	start := firstID
+	for _, r := range ranges {
+		if end := r.Start + r.Count; end > start {
+			start = end
+		}
+	}
+	return start
+}
+
+// Ensure adds a range of given size for the user (identified by the first
+// name, other names such as the uid are also checked) to the subuid/subgid
+// file at path if the user does not have a range at least that large. The
+// file is backed up before it is modified. Returns the range of the user.
+func Ensure(path string, count int, names ...string) (Range, bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return Range{}, false, fmt.Errorf("subid: cannot read %s: %v", path, err)
+	}
+	ranges := Parse(string(b))
+	if r, ok := Find(ranges, names...); ok && r.Count >= count {
+		return r, false, nil
+	}
+
+	r := Range{names[0], Allocate(ranges), count}
+	out := string(b)
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	out += fmt.Sprintf("%s:%d:%d\n", r.Name, r.Start, r.Count)
+
+	if err := backup.Save(path); err != nil {
+		return Range{}, false, err
+	}
+	if err := ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+		return Range{}, false, fmt.Errorf("subid: cannot write %s: %v", path, err)
+	}
+	return r, true, nil
+}
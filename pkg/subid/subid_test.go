@@ -0,0 +1,71 @@
+package subid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_Parse(t *testing.T) {
+	in := `# comment
+azureuser:100000:65536
+dockremap:165536:65536
+
+invalid line
+`
+	expected := []Range{{"azureuser", 100000, 65536}, {"dockremap", 165536, 65536}}
+	if out := Parse(in); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("got: %v, expected: %v", out, expected)
+	}
+}
+
+func Test_Allocate(t *testing.T) {
+	if s := Allocate(nil); s != 100000 {
+		t.Fatalf("got %d, expected 100000", s)
+	}
+	if s := Allocate([]Range{{"a", 231072, 65536}, {"b", 100000, 65536}}); s != 296608 {
+		t.Fatalf("got %d, expected 296608", s)
+	}
+}
+
+func Test_Ensure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = filepath.Join(dir, "state")
+
+	path := filepath.Join(dir, "subuid")
+	if err := ioutil.WriteFile(path, []byte("azureuser:100000:65536"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, changed, err := Ensure(path, DefaultCount, "dockremap", "998")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || r != (Range{"dockremap", 165536, 65536}) {
+		t.Fatalf("got %v changed=%v", r, changed)
+	}
+	// idempotent
+	r2, changed, err := Ensure(path, DefaultCount, "dockremap", "998")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || r2 != r {
+		t.Fatalf("got %v changed=%v, expected unchanged %v", r2, changed, r)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "azureuser:100000:65536\ndockremap:165536:65536\n"; string(b) != expected {
+		t.Fatalf("got:\n%s\nexpected:\n%s", b, expected)
+	}
+}
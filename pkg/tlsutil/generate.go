@@ -0,0 +1,155 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// KeyPair is a certificate and its private key.
+type KeyPair struct {
+	Cert    *x509.Certificate
+	Key     *ecdsa.PrivateKey
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// LoadKeyPair parses the PEM encoded certificate and EC private key.
+func LoadKeyPair(certPEM, keyPEM []byte) (*KeyPair, error) {
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected private key type %T", k)
+	}
+	if ok, err := keyMatches(certs[0], key); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("private key does not match the certificate (%s)", describe(certs[0]))
+	}
+	return &KeyPair{certs[0], key, certPEM, keyPEM}, nil
+}
+
+// GenerateCA creates a self-signed CA certificate valid for the given
+// duration.
+func GenerateCA(cn string, validity time.Duration) (*KeyPair, error) {
+	tmpl, err := template(cn, validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	return generate(tmpl, nil)
+}
+
+// GenerateServerCert creates a server certificate signed by the CA for the
+// given host names and IP addresses.
+func GenerateServerCert(ca *KeyPair, cn string, hosts []string, validity time.Duration) (*KeyPair, error) {
+	tmpl, err := template(cn, validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	return generate(tmpl, ca)
+}
+
+// GenerateClientCert creates a client certificate signed by the CA.
+func GenerateClientCert(ca *KeyPair, cn string, validity time.Duration) (*KeyPair, error) {
+	tmpl, err := template(cn, validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return generate(tmpl, ca)
+}
+
+// Covers reports whether the certificate is signed by the CA for the given
+// usage, does not need renewal (i.e. does not expire within the expiry
+// warning period) and is valid for all the given host names and IPs.
+func (kp *KeyPair) Covers(ca *KeyPair, usage x509.ExtKeyUsage, hosts []string, now time.Time) bool {
+	if kp.Cert.NotAfter.Sub(now) < ExpiryWarningPeriod || now.Before(kp.Cert.NotBefore) {
+		return false
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	if _, err := kp.Cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{usage},
+	}); err != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if kp.Cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func template(cn string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate serial number: %v", err)
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-time.Hour), // tolerate clock skew
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// generate creates a key and a certificate from the template, signed by the
+// given CA or self-signed if ca is nil.
+func generate(tmpl *x509.Certificate, ca *KeyPair) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate key: %v", err)
+	}
+	parent, signer := tmpl, key
+	if ca != nil {
+		parent, signer = ca.Cert, ca.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
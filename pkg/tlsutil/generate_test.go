@@ -0,0 +1,73 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func Test_Generate(t *testing.T) {
+	ca, err := GenerateCA("docker-ca", 24*time.Hour*365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := GenerateServerCert(ca, "myvm", []string{"myvm", "localhost", "10.0.0.4", "127.0.0.1"}, 24*time.Hour*90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Validate(ca.CertPEM, srv.CertPEM, srv.KeyPEM, []string{"myvm"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) != 0 {
+		t.Fatalf("unexpected warnings: %v", w)
+	}
+
+	now := time.Now()
+	if !srv.Covers(ca, x509.ExtKeyUsageServerAuth, []string{"myvm", "10.0.0.4"}, now) {
+		t.Fatal("server cert should cover its hosts")
+	}
+	if srv.Covers(ca, x509.ExtKeyUsageServerAuth, []string{"othervm"}, now) {
+		t.Fatal("server cert should not cover other hosts")
+	}
+	if srv.Covers(ca, x509.ExtKeyUsageServerAuth, nil, now.Add(24*time.Hour*80)) {
+		t.Fatal("server cert expiring soon should need renewal")
+	}
+
+	client, err := GenerateClientCert(ca, "client", 24*time.Hour*90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.Covers(ca, x509.ExtKeyUsageClientAuth, nil, now) {
+		t.Fatal("client cert should be valid for client auth")
+	}
+	if client.Covers(ca, x509.ExtKeyUsageServerAuth, nil, now) {
+		t.Fatal("client cert should not be valid for server auth")
+	}
+
+	if _, err := ValidateClient(ca.CertPEM, client.CertPEM, client.KeyPEM, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateClient(ca.CertPEM, srv.CertPEM, srv.KeyPEM, now); err == nil {
+		t.Fatal("expected error validating server cert as client cert")
+	}
+
+	otherCA, err := GenerateCA("other-ca", 24*time.Hour*365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Covers(otherCA, x509.ExtKeyUsageClientAuth, nil, now) {
+		t.Fatal("client cert should not chain to another CA")
+	}
+
+	loaded, err := LoadKeyPair(client.CertPEM, client.KeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(client.Cert) {
+		t.Fatal("loaded certificate is different")
+	}
+	if _, err := LoadKeyPair(client.CertPEM, srv.KeyPEM); err == nil {
+		t.Fatal("expected error for mismatching key")
+	}
+}
@@ -0,0 +1,224 @@
+// Package tlsutil parses and validates the TLS certificates and keys used
+// for the remote access to the docker engine.
+package tlsutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExpiryWarningPeriod is how long before the expiry of a certificate a
+// warning is issued.
+const ExpiryWarningPeriod = 30 * 24 * time.Hour
+
+// ParseCertificates parses the PEM encoded certificates in b, in the order
+// they appear.
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			break
+		}
+		if blk.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q, expected CERTIFICATE", blk.Type)
+		}
+		c, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate: %v", err)
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return out, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or EC private key in PKCS#1, SEC 1
+// or PKCS#8 format.
+func ParsePrivateKey(b []byte) (crypto.Signer, error) {
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			return nil, errors.New("no PEM encoded private key found")
+		}
+		switch blk.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(blk.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(blk.Bytes)
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			switch k := k.(type) {
+			case *rsa.PrivateKey:
+				return k, nil
+			case *ecdsa.PrivateKey:
+				return k, nil
+			default:
+				return nil, fmt.Errorf("unsupported private key type %T", k)
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted private keys are not supported")
+		}
+		// skip other blocks such as "EC PARAMETERS"
+	}
+}
+
+// Validate verifies that the server certificate (optionally followed by the
+// intermediate certificates) and its key are usable by the docker engine for
+// TLS: the key matches the certificate, the certificate chains to the CA for
+// server authentication, has subject alternative names and is currently valid.
+// Non-fatal problems, such as a certificate expiring soon or the server
+// certificate not matching any of the given host names/IPs, are returned as
+// warnings.
+func Validate(caPEM, certPEM, keyPEM []byte, hosts []string, now time.Time) (warnings []string, err error) {
+	cert, warnings, err := validate(caPEM, certPEM, keyPEM, "server", x509.ExtKeyUsageServerAuth, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+		return nil, fmt.Errorf("server certificate (%s) has no subject alternative names, which are required by docker clients", describe(cert))
+	}
+	if len(hosts) > 0 && !matchesAny(cert, hosts) {
+		warnings = append(warnings, fmt.Sprintf("server certificate SANs %v do not match any of the VM host names/IPs %v",
+			append(cert.DNSNames, ipStrings(cert.IPAddresses)...), hosts))
+	}
+	return warnings, nil
+}
+
+// ValidateClient verifies that the client certificate and its key can be
+// used for authenticating to docker engines trusting the CA: the key matches
+// the certificate, the certificate chains to the CA for client
+// authentication and is currently valid. Certificates expiring soon are
+// returned as warnings.
+func ValidateClient(caPEM, certPEM, keyPEM []byte, now time.Time) (warnings []string, err error) {
+	_, warnings, err = validate(caPEM, certPEM, keyPEM, "client", x509.ExtKeyUsageClientAuth, now)
+	return warnings, err
+}
+
+func validate(caPEM, certPEM, keyPEM []byte, kind string, usage x509.ExtKeyUsage, now time.Time) (*x509.Certificate, []string, error) {
+	cas, err := ParseCertificates(caPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CA certificate: %v", err)
+	}
+	chain, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s certificate: %v", kind, err)
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s key: %v", kind, err)
+	}
+	cert := chain[0]
+
+	if ok, err := keyMatches(cert, key); err != nil {
+		return nil, nil, err
+	} else if !ok {
+		return nil, nil, fmt.Errorf("%s key does not match the %s certificate (%s)", kind, kind, describe(cert))
+	}
+
+	var warnings []string
+	for _, c := range append(chain, cas...) {
+		if now.Before(c.NotBefore) {
+			return nil, nil, fmt.Errorf("certificate %s is not valid before %s", describe(c), c.NotBefore.UTC().Format(time.RFC3339))
+		}
+		if now.After(c.NotAfter) {
+			return nil, nil, fmt.Errorf("certificate %s has expired on %s", describe(c), c.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if c.NotAfter.Sub(now) < ExpiryWarningPeriod {
+			warnings = append(warnings, fmt.Sprintf("certificate %s expires on %s", describe(c), c.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	roots := x509.NewCertPool()
+	for _, c := range cas {
+		roots.AddCert(c)
+	}
+	inter := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		inter.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}); err != nil {
+		return nil, nil, fmt.Errorf("%s certificate (%s) does not chain to the CA certificate: %v", kind, describe(cert), err)
+	}
+	return cert, warnings, nil
+}
+
+func keyMatches(cert *x509.Certificate, key crypto.Signer) (bool, error) {
+	a, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false, fmt.Errorf("unsupported public key in certificate: %v", err)
+	}
+	b, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return false, fmt.Errorf("unsupported private key: %v", err)
+	}
+	return bytes.Equal(a, b), nil
+}
+
+func matchesAny(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(c *x509.Certificate) string {
+	if c.Subject.CommonName != "" {
+		return fmt.Sprintf("CN=%s", c.Subject.CommonName)
+	}
+	return fmt.Sprintf("serial %s", c.SerialNumber)
+}
+
+func ipStrings(l []net.IP) []string {
+	var out []string
+	for _, ip := range l {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+// HostNames returns the host name and the non-loopback IP addresses of the
+// machine, to be matched against the SANs of the server certificate.
+func HostNames() []string {
+	var out []string
+	if h, err := os.Hostname(); err == nil && h != "" {
+		out = append(out, h)
+		if i := strings.Index(h, "."); i > 0 {
+			out = append(out, h[:i])
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return out
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && !n.IP.IsLinkLocalUnicast() {
+			out = append(out, n.IP.String())
+		}
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pem  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, key crypto.Signer, notAfter time.Time, sans ...string) *testCert {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	for _, s := range sans {
+		if ip := net.ParseIP(s); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, s)
+		}
+	}
+	signer, parentCert := key, tmpl
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		signer, parentCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{c, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func ecKey(t *testing.T) *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func Test_ParsePrivateKey(t *testing.T) {
+	rk, ek := rsaKey(t), ecKey(t)
+	ecDER, err := x509.MarshalECPrivateKey(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p8, err := x509.MarshalPKCS8PrivateKey(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rk)},
+		{Type: "EC PRIVATE KEY", Bytes: ecDER},
+		{Type: "PRIVATE KEY", Bytes: p8},
+	} {
+		if _, err := ParsePrivateKey(pem.EncodeToMemory(b)); err != nil {
+			t.Fatalf("cannot parse %s: %v", b.Type, err)
+		}
+	}
+	if _, err := ParsePrivateKey([]byte("garbage")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, rsaKey(t), now.AddDate(1, 0, 0))
+	otherCA := newTestCert(t, "other-ca", nil, rsaKey(t), now.AddDate(1, 0, 0))
+	srvKey := ecKey(t)
+	srv := newTestCert(t, "server", ca, srvKey, now.AddDate(1, 0, 0), "myvm", "10.0.0.4")
+	keyDER, err := x509.MarshalECPrivateKey(srvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	otherKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey(t))})
+
+	w, err := Validate(ca.pem, srv.pem, keyPEM, []string{"myvm", "10.0.0.4"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) != 0 {
+		t.Fatalf("unexpected warnings: %v", w)
+	}
+
+	w, err = Validate(ca.pem, srv.pem, keyPEM, []string{"othervm"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) != 1 || !strings.Contains(w[0], "do not match") {
+		t.Fatalf("expected SAN warning, got: %v", w)
+	}
+
+	w, err = Validate(ca.pem, srv.pem, keyPEM, nil, now.AddDate(0, 11, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w) == 0 || !strings.Contains(w[0], "expires on") {
+		t.Fatalf("expected expiry warning, got: %v", w)
+	}
+
+	noSAN := newTestCert(t, "server", ca, srvKey, now.AddDate(1, 0, 0))
+	for _, c := range []struct {
+		name          string
+		ca, cert, key []byte
+		at            time.Time
+		err           string
+	}{
+		{"swapped", ca.pem, keyPEM, srv.pem, now, "invalid server certificate"},
+		{"wrong key", ca.pem, srv.pem, otherKeyPEM, now, "does not match"},
+		{"wrong ca", otherCA.pem, srv.pem, keyPEM, now, "does not chain"},
+		{"expired", ca.pem, srv.pem, keyPEM, now.AddDate(2, 0, 0), "has expired"},
+		{"not yet valid", ca.pem, srv.pem, keyPEM, now.AddDate(0, 0, -1), "not valid before"},
+		{"no SAN", ca.pem, noSAN.pem, keyPEM, now, "no subject alternative names"},
+	} {
+		_, err := Validate(c.ca, c.cert, c.key, nil, c.at)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: got error %v, expected: %q", c.name, err, c.err)
+		}
+	}
+}
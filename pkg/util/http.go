@@ -0,0 +1,27 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// HTTPClient is the client used by the extension handler for HTTP requests.
+var HTTPClient = http.DefaultClient
+
+// Download returns the response body of a GET request to the given url.
+func Download(url string) ([]byte, error) {
+	resp, err := HTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("response status code from %s: %s", url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %v", url, err)
+	}
+	return b, nil
+}
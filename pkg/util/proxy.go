@@ -0,0 +1,110 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyConfig describes the HTTP proxies outbound connections should go through.
+type ProxyConfig struct {
+	HTTP    string // proxy URL for http requests
+	HTTPS   string // proxy URL for https requests
+	NoProxy string // comma-separated hosts, domains, IPs or CIDRs to connect directly
+}
+
+// IsSet reports whether any proxy is configured.
+func (p ProxyConfig) IsSet() bool { return p.HTTP != "" || p.HTTPS != "" }
+
+// WithCredentials returns a copy of the proxy configuration where the given
+// credentials are embedded in the proxy URLs.
+func (p ProxyConfig) WithCredentials(username, password string) (ProxyConfig, error) {
+	if username == "" {
+		return p, nil
+	}
+	for _, v := range []*string{&p.HTTP, &p.HTTPS} {
+		if *v == "" {
+			continue
+		}
+		u, err := url.Parse(*v)
+		if err != nil {
+			return p, fmt.Errorf("invalid proxy url: %v", err)
+		}
+		u.User = url.UserPassword(username, password)
+		*v = u.String()
+	}
+	return p, nil
+}
+
+// Validate returns error if the proxy URLs are not valid.
+func (p ProxyConfig) Validate() error {
+	for _, v := range []string{p.HTTP, p.HTTPS} {
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url %q: %v", v, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid proxy url %q: expected http(s)://host:port", v)
+		}
+	}
+	return nil
+}
+
+// Env returns the environment variables (in both upper and lower case forms,
+// as programs disagree) configuring the proxy.
+func (p ProxyConfig) Env() map[string]string {
+	m := make(map[string]string)
+	for k, v := range map[string]string{
+		"HTTP_PROXY":  p.HTTP,
+		"HTTPS_PROXY": p.HTTPS,
+		"NO_PROXY":    p.NoProxy,
+	} {
+		if v != "" {
+			m[k] = v
+			m[strings.ToLower(k)] = v
+		}
+	}
+	return m
+}
+
+// Proxy returns the proxy URL for the given request, it can be used as
+// http.Transport.Proxy.
+func (p ProxyConfig) Proxy(req *http.Request) (*url.URL, error) {
+	proxy := p.HTTP
+	if req.URL.Scheme == "https" {
+		proxy = p.HTTPS
+	}
+	if proxy == "" || p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+// bypass reports whether the host matches an entry in NoProxy.
+func (p ProxyConfig) bypass(host string) bool {
+	ip := net.ParseIP(host)
+	for _, e := range strings.Split(p.NoProxy, ",") {
+		e = strings.TrimSpace(e)
+		switch {
+		case e == "":
+			continue
+		case e == "*":
+			return true
+		case ip != nil && strings.Contains(e, "/"):
+			if _, n, err := net.ParseCIDR(e); err == nil && n.Contains(ip) {
+				return true
+			}
+		default:
+			e = strings.TrimPrefix(e, ".")
+			if host == e || strings.HasSuffix(host, "."+e) {
+				return true
+			}
+		}
+	}
+	return false
+}
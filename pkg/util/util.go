@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -52,6 +53,16 @@ func GetAzureUser() (string, error) {
 	return v.UserName, nil
 }
 
+// ScriptDir returns the absolute path of the directory the running executable
+// is in.
+func ScriptDir() (string, error) {
+	p, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(p), nil
+}
+
 // PathExists checks if a path is a directory or file on the
 // filesystem.
 func PathExists(path string) (bool, error) {
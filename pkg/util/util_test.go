@@ -39,7 +39,7 @@ func Test_ScriptDir(t *testing.T) {
 		t.Fatal(err)
 	}
 	if !st.Mode().IsDir() {
-		t.Fatalf("%s is not dir")
+		t.Fatalf("%s is not dir", s)
 	}
 	t.Logf("Script dir: %s", s)
 }
@@ -69,7 +69,7 @@ func Test_PathExists(t *testing.T) {
 			t.Fatal(err)
 		}
 		if ok != v.exists {
-			t.Fatal("got %v for %s, expected: %v", ok, v.path, v.exists)
+			t.Fatalf("got %v for %s, expected: %v", ok, v.path, v.exists)
 		}
 	}
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+// pluginsState keeps the plugins installed by the extension, keyed by their
+// names.
+const pluginsState = "plugins"
+
+// installedPlugin is a plugin managed by the extension.
+type installedPlugin struct {
+	Reference string            `json:"reference"`
+	Settings  map[string]string `json:"settings"`
+	Installed bool              `json:"installed"` // false if it existed before, it is never removed then
+}
+
+// name returns the name of the plugin in the engine.
+func (p pluginSettings) name() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	return p.Reference
+}
+
+// updatePlugins installs and enables the configured plugins, upgrades the
+// plugins whose reference is changed, updates their settings and removes
+// the plugins installed previously but no longer configured.
+func updatePlugins(plugins []pluginSettings) error {
+	configured := make(map[string]pluginSettings)
+	for _, p := range plugins {
+		if p.Reference == "" {
+			return errors.New("plugin reference is required")
+		}
+		if _, ok := configured[p.name()]; ok {
+			return fmt.Errorf("plugin %s is specified more than once", p.name())
+		}
+		configured[p.name()] = p
+	}
+	prev := make(map[string]installedPlugin)
+	if _, err := statefile.Get(pluginsState, &prev); err != nil {
+		return fmt.Errorf("error reading installed plugins: %v", err)
+	}
+
+	for name, p := range prev {
+		if _, ok := configured[name]; ok {
+			continue
+		}
+		if !p.Installed {
+			log.Printf("plugin %s was not installed by the extension, not removing", name)
+		} else {
+			log.Printf("Removing plugin %s", name)
+			if out, err := executil.Exec("docker", "plugin", "rm", name); err != nil && !strings.Contains(string(out), "not found") {
+				// kept to retry upon the next enable
+				addWarning("cannot remove plugin %s, it may be in use: %v: %s", name, err, strings.TrimSpace(string(out)))
+				continue
+			}
+		}
+		delete(prev, name)
+		if err := savePlugins(prev); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range plugins {
+		name := p.name()
+		installed, err := updatePlugin(p, prev[name])
+		if err != nil {
+			return err
+		}
+		prev[name] = installedPlugin{p.Reference, p.Settings, installed}
+		if err := savePlugins(prev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePlugin installs the plugin if it does not exist, or upgrades it or
+// changes its settings if they are different than the ones it was installed
+// with (prev is empty if it is not managed by the extension yet). Returns
+// whether the plugin is installed by the extension.
+func updatePlugin(p pluginSettings, prev installedPlugin) (bool, error) {
+	name := p.name()
+	out, err := executil.Exec("docker", "plugin", "inspect", "--format", "{{.PluginReference}}|{{.Enabled}}", name)
+	if err != nil {
+		log.Printf("Installing plugin %s from %s", name, p.Reference)
+		args := []string{"plugin", "install", "--disable"}
+		if p.GrantAllPermissions {
+			args = append(args, "--grant-all-permissions")
+		}
+		if p.Alias != "" {
+			args = append(args, "--alias", p.Alias)
+		}
+		args = append(append(args, p.Reference), pluginSettingArgs(p.Settings)...)
+		if out, err := executil.Exec("docker", args...); err != nil {
+			log.Printf("%s", string(out))
+			return false, fmt.Errorf("error installing plugin %s (plugins requiring permissions need grant-all-permissions): %v", name, err)
+		}
+		return true, enablePlugin(name)
+	}
+	f := strings.SplitN(strings.TrimSpace(string(out)), "|", 2)
+	if len(f) != 2 {
+		return false, fmt.Errorf("cannot parse state of plugin %s: %q", name, string(out))
+	}
+	installedRef, enabled := f[0], f[1] == "true"
+
+	upgrade := prev.Reference != p.Reference
+	if prev.Reference == "" {
+		// not installed by the extension, keep it if it is the same plugin
+		upgrade = normalizePluginRef(installedRef) != normalizePluginRef(p.Reference)
+	}
+	reconfigure := !equalSettings(prev.Settings, p.Settings)
+	if !upgrade && !reconfigure {
+		if !enabled {
+			return prev.Installed, enablePlugin(name)
+		}
+		log.Printf("plugin %s is up to date", name)
+		return prev.Installed, nil
+	}
+
+	if enabled {
+		if out, err := executil.Exec("docker", "plugin", "disable", name); err != nil {
+			log.Printf("%s", string(out))
+			return false, fmt.Errorf("error disabling plugin %s (it may be in use): %v", name, err)
+		}
+	}
+	if upgrade {
+		log.Printf("Upgrading plugin %s from %s to %s", name, installedRef, p.Reference)
+		args := []string{"plugin", "upgrade", "--skip-remote-check"}
+		if p.GrantAllPermissions {
+			args = append(args, "--grant-all-permissions")
+		}
+		if out, err := executil.Exec("docker", append(args, name, p.Reference)...); err != nil {
+			log.Printf("%s", string(out))
+			return false, fmt.Errorf("error upgrading plugin %s: %v", name, err)
+		}
+	}
+	if len(p.Settings) > 0 {
+		log.Printf("Configuring plugin %s", name)
+		if out, err := executil.Exec("docker", append([]string{"plugin", "set", name}, pluginSettingArgs(p.Settings)...)...); err != nil {
+			log.Printf("%s", string(out))
+			return false, fmt.Errorf("error configuring plugin %s: %v", name, err)
+		}
+	}
+	return prev.Installed, enablePlugin(name)
+}
+
+func enablePlugin(name string) error {
+	log.Printf("Enabling plugin %s", name)
+	if out, err := executil.Exec("docker", "plugin", "enable", name); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("error enabling plugin %s: %v", name, err)
+	}
+	return nil
+}
+
+// pluginSettingArgs returns the key=value arguments of the plugin settings in
+// sorted order.
+func pluginSettingArgs(settings map[string]string) []string {
+	var out []string
+	for k, v := range settings {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func equalSettings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePluginRef returns the plugin reference in the form reported by
+// the engine, e.g. "docker.io/vieux/sshfs:latest" for "vieux/sshfs" and
+// "docker.io/library/sshfs:latest" for "sshfs".
+func normalizePluginRef(ref string) string {
+	if i := strings.Index(ref, "/"); i < 0 || (!strings.ContainsAny(ref[:i], ".:") && ref[:i] != "localhost") {
+		ref = "docker.io/" + ref
+	}
+	// official images of docker hub are in the library namespace
+	if path := strings.TrimPrefix(ref, "docker.io/"); path != ref && !strings.Contains(path, "/") {
+		ref = "docker.io/library/" + path
+	}
+	if !strings.Contains(ref, "@") && !strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		ref += ":latest"
+	}
+	return ref
+}
+
+func savePlugins(plugins map[string]installedPlugin) error {
+	if err := backup.Save(statefile.Path(pluginsState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(pluginsState, plugins); err != nil {
+		return fmt.Errorf("error saving installed plugins: %v", err)
+	}
+	return nil
+}
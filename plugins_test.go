@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_normalizePluginRef(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"sshfs", "docker.io/library/sshfs:latest"},
+		{"sshfs:next", "docker.io/library/sshfs:next"},
+		{"docker.io/sshfs", "docker.io/library/sshfs:latest"},
+		{"vieux/sshfs", "docker.io/vieux/sshfs:latest"},
+		{"vieux/sshfs:next", "docker.io/vieux/sshfs:next"},
+		{"docker.io/vieux/sshfs:latest", "docker.io/vieux/sshfs:latest"},
+		{"registry.example.com:5000/sshfs", "registry.example.com:5000/sshfs:latest"},
+		{"localhost/sshfs:1.0", "localhost/sshfs:1.0"},
+		{"vieux/sshfs@sha256:abc", "docker.io/vieux/sshfs@sha256:abc"},
+	} {
+		if out := normalizePluginRef(c.in); out != c.out {
+			t.Fatalf("got %q for %q, expected: %q", out, c.in, c.out)
+		}
+	}
+}
+
+func Test_equalSettings(t *testing.T) {
+	for _, c := range []struct {
+		a, b map[string]string
+		out  bool
+	}{
+		{nil, nil, true},
+		{nil, map[string]string{}, true},
+		{map[string]string{"DEBUG": "1"}, map[string]string{"DEBUG": "1"}, true},
+		{map[string]string{"DEBUG": "1"}, map[string]string{"DEBUG": "0"}, false},
+		{map[string]string{"DEBUG": "1"}, map[string]string{"debug": "1"}, false},
+		{map[string]string{"DEBUG": "1"}, nil, false},
+		{map[string]string{"DEBUG": ""}, map[string]string{"A": "", "DEBUG": ""}, false},
+	} {
+		if out := equalSettings(c.a, c.b); out != c.out {
+			t.Fatalf("got %v for %v and %v, expected: %v", out, c.a, c.b, c.out)
+		}
+	}
+}
+
+func Test_pluginSettingArgs(t *testing.T) {
+	if out := pluginSettingArgs(nil); out != nil {
+		t.Fatalf("expected no arguments, got: %v", out)
+	}
+	out := pluginSettingArgs(map[string]string{"sshkey.source": "/root/.ssh", "DEBUG": "1", "opts": "a=b"})
+	expected := []string{"DEBUG=1", "opts=a=b", "sshkey.source=/root/.ssh"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("got %v, expected: %v", out, expected)
+	}
+}
+
+// fakePluginDocker is a docker command logging its arguments, where the
+// plugins named in $PLUGINS exist and the plugin "busy" is in use.
+const fakePluginDocker = `#!/bin/sh
+echo "$*" >> "$DOCKER_LOG"
+case "$1 $2" in
+"plugin inspect")
+	for p in $PLUGINS; do
+		if [ "$p" = "$5" ]; then echo "docker.io/$p:latest|true"; exit 0; fi
+	done
+	echo "plugin $5 not found"; exit 1;;
+"plugin rm")
+	if [ "$3" = "busy" ]; then echo "plugin busy is in use"; exit 1; fi;;
+esac
+`
+
+func Test_updatePlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = dir
+	defer func(w []string) { warnings = w }(warnings)
+	warnings = nil
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker"), []byte(fakePluginDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dockerLog := filepath.Join(dir, "docker.log")
+	for k, v := range map[string]string{
+		"PATH":       dir + ":" + os.Getenv("PATH"),
+		"DOCKER_LOG": dockerLog,
+		"PLUGINS":    "vieux/sshfs",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	if err := statefile.Set(pluginsState, map[string]installedPlugin{
+		"old":    {Reference: "old", Installed: true},
+		"busy":   {Reference: "busy", Installed: true},
+		"manual": {Reference: "manual"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updatePlugins([]pluginSettings{
+		{Reference: "vieux/sshfs"},
+		{Reference: "rexray/ebs"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(dockerLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := string(b)
+	for _, c := range []string{"plugin rm old\n", "plugin rm busy\n", "plugin install --disable rexray/ebs\n"} {
+		if !strings.Contains(calls, c) {
+			t.Fatalf("expected %q in docker calls:\n%s", c, calls)
+		}
+	}
+	for _, c := range []string{"--force", "rm manual", "plugin install --disable vieux/sshfs"} {
+		if strings.Contains(calls, c) {
+			t.Fatalf("unexpected %q in docker calls:\n%s", c, calls)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "busy") {
+		t.Fatalf("expected warning for plugin in use, got: %v", warnings)
+	}
+
+	var state map[string]installedPlugin
+	if _, err := statefile.Get(pluginsState, &state); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]installedPlugin{
+		"busy":        {Reference: "busy", Installed: true}, // removal is retried
+		"vieux/sshfs": {Reference: "vieux/sshfs"},
+		"rexray/ebs":  {Reference: "rexray/ebs", Installed: true},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Fatalf("got state %+v, expected: %+v", state, expected)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/policy"
+)
+
+// checkPolicy checks the docker daemon configuration against the policy level
+// in the settings. cfg is the effective daemon.json configuration, including
+// the keys not managed by the extension. Findings rejected at that level are
+// returned as an error explaining the decision, the others are reported as
+// warnings in the status.
+func checkPolicy(s DockerHandlerSettings, args string, cfg dockeropts.DaemonConfig) error {
+	level, err := policy.ParseLevel(s.Docker.Policy)
+	if err != nil {
+		return err
+	}
+	findings, err := policy.Check(policy.Input{
+		Args:           strings.Fields(args),
+		Config:         cfg,
+		PublishedPorts: composePorts(s.ComposeJson),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot check docker configuration against policy: %v", err)
+	}
+	rejected, warned := policy.Evaluate(level, findings)
+	for _, f := range warned {
+		addWarning("policy (%s severity, allowed at level %q): %s", f.Severity, level, f)
+	}
+	if len(rejected) == 0 {
+		log.Printf("docker configuration complies with policy level %q", level)
+		return nil
+	}
+	var l []string
+	for _, f := range rejected {
+		l = append(l, fmt.Sprintf("%s (%s severity)", f, f.Severity))
+	}
+	return fmt.Errorf("docker configuration is rejected by policy level %q: %s. Fix the configuration or lower docker.policy", level, strings.Join(l, "; "))
+}
+
+// composePorts returns the port mappings of the services in the given compose
+// configuration (in version 1 or later formats) in the short syntax.
+func composePorts(compose map[string]interface{}) []string {
+	services := compose
+	if v, ok := compose["services"].(map[string]interface{}); ok {
+		services = v
+	}
+	var out []string
+	for _, svc := range services {
+		m, ok := svc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ports, _ := m["ports"].([]interface{})
+		for _, p := range ports {
+			switch p := p.(type) {
+			case string:
+				out = append(out, p)
+			case float64:
+				out = append(out, fmt.Sprintf("%v", p))
+			case map[string]interface{}:
+				// long syntax
+				if p["published"] == nil {
+					continue
+				}
+				spec := fmt.Sprintf("%v:%v", p["published"], p["target"])
+				if ip, ok := p["host_ip"].(string); ok && ip != "" {
+					if strings.Contains(ip, ":") {
+						ip = "[" + ip + "]"
+					}
+					spec = ip + ":" + spec
+				}
+				out = append(out, spec)
+			}
+		}
+	}
+	return out
+}
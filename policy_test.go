@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_checkPolicy_unmanagedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = dir
+
+	path := filepath.Join(dir, "daemon.json")
+	if err := ioutil.WriteFile(path, []byte(`{"hosts": ["tcp://0.0.0.0:2375"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	managed := dockeropts.DaemonConfig{"log-driver": "json-file"}
+	cfg, err := effectiveDaemonConfig(path, daemonConfigState, managed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s DockerHandlerSettings
+	if err := checkPolicy(s, "", cfg); err == nil {
+		t.Fatal("expected unmanaged tcp host to be rejected at the default level")
+	}
+
+	// keys managed previously are replaced by the new configuration
+	if err := statefile.Set(daemonConfigState, []string{"hosts"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = effectiveDaemonConfig(path, daemonConfigState, managed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPolicy(s, "", cfg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/driver"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+	"github.com/Azure/azure-docker-extension/pkg/vmextension"
+)
+
+const (
+	// pruneState keeps the configuration of the scheduled prune operation,
+	// which runs outside of the agent and does not read the settings.
+	pruneState = "prune"
+
+	// composeProjectLabel is set by compose on the containers and volumes of
+	// its projects, which are never pruned.
+	composeProjectLabel = "com.docker.compose.project"
+)
+
+// pruneSchedules are the supported schedules, understood both by systemd
+// timers and cron.
+var pruneSchedules = []string{"hourly", "daily", "weekly", "monthly"}
+
+// pruneFilterKeys are the supported prune filters.
+var pruneFilterKeys = []string{"until", "label"}
+
+// pruneConfig is the configuration of the scheduled prune operation.
+type pruneConfig struct {
+	Filters    []string `json:"filters"`
+	Volumes    bool     `json:"volumes"`
+	DockerHost string   `json:"dockerHost"` // empty for the system docker daemon
+}
+
+// updatePruneSchedule saves the prune configuration and schedules the prune
+// operation, or removes the schedule if prune is not configured.
+func updatePruneSchedule(d driver.DistroDriver, s pruneSettings) error {
+	if err := backup.Save(statefile.Path(pruneState)); err != nil {
+		return err
+	}
+	if s.Schedule == "" {
+		if err := d.UpdatePruneSchedule("", ""); err != nil {
+			return fmt.Errorf("error removing prune schedule: %v", err)
+		}
+		return statefile.Delete(pruneState)
+	}
+	if err := validatePruneSettings(s); err != nil {
+		return err
+	}
+
+	// the engine in use, such as the rootless or podman engine
+	c := pruneConfig{s.Filters, s.Volumes, os.Getenv("DOCKER_HOST")}
+	if err := statefile.Set(pruneState, c); err != nil {
+		return fmt.Errorf("error saving prune configuration: %v", err)
+	}
+	bin, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return fmt.Errorf("cannot find path of the extension handler: %v", err)
+	}
+	if err := d.UpdatePruneSchedule(s.Schedule, bin+" prune"); err != nil {
+		return fmt.Errorf("error scheduling prune: %v", err)
+	}
+	log.Printf("prune scheduled %s", s.Schedule)
+	return nil
+}
+
+func validatePruneSettings(s pruneSettings) error {
+	if !contains(pruneSchedules, s.Schedule) {
+		return fmt.Errorf("invalid prune.schedule %q, expected one of: %s", s.Schedule, strings.Join(pruneSchedules, ", "))
+	}
+	for _, f := range s.Filters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[1] == "" || !contains(pruneFilterKeys, kv[0]) {
+			return fmt.Errorf("invalid prune filter %q, expected until=<duration or timestamp> or label=<key>[=<value>]", f)
+		}
+	}
+	return nil
+}
+
+// prune removes the stopped containers, dangling images and (if configured)
+// unused volumes matching the configured filters, except the containers and
+// volumes of compose projects. It is run on the prune schedule.
+func prune(he vmextension.HandlerEnvironment, d driver.DistroDriver) error {
+	var c pruneConfig
+	if ok, err := statefile.Get(pruneState, &c); err != nil {
+		return fmt.Errorf("error reading prune configuration: %v", err)
+	} else if !ok {
+		log.Printf("prune not configured, noop")
+		return nil
+	}
+	if c.DockerHost != "" {
+		os.Setenv("DOCKER_HOST", c.DockerHost)
+	}
+
+	volumesAll := false
+	if c.Volumes {
+		volumesAll = engineAPIVersionAtLeast(1, 42)
+	}
+	var failed []string
+	for _, args := range pruneCommands(c, volumesAll) {
+		log.Printf("Pruning %ss", args[0])
+		out, err := executil.Exec("docker", args...)
+		log.Printf("%s", strings.TrimSpace(string(out)))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("'docker %s prune' failed: %v", args[0], err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// pruneCommands returns the arguments of the prune commands for the given
+// configuration. volumesAll prunes the named volumes as well, which engine API
+// 1.42 and later only do with the all=true filter.
+func pruneCommands(c pruneConfig, volumesAll bool) [][]string {
+	exclude := "label!=" + composeProjectLabel
+	var volumeFilters []string
+	for _, f := range c.Filters {
+		// volumes do not have creation time filter
+		if !strings.HasPrefix(f, "until=") {
+			volumeFilters = append(volumeFilters, f)
+		}
+	}
+	if volumesAll {
+		volumeFilters = append(volumeFilters, "all=true")
+	}
+	objects := []struct {
+		name    string
+		filters []string
+	}{
+		{"container", append(append([]string{}, c.Filters...), exclude)},
+		{"image", c.Filters},
+	}
+	if c.Volumes {
+		objects = append(objects, struct {
+			name    string
+			filters []string
+		}{"volume", append(volumeFilters, exclude)})
+	}
+
+	var out [][]string
+	for _, o := range objects {
+		args := []string{o.name, "prune", "--force"}
+		for _, f := range o.filters {
+			args = append(args, "--filter", f)
+		}
+		out = append(out, args)
+	}
+	return out
+}
+
+// engineAPIVersionAtLeast reports whether the API version of the engine is at
+// least major.minor, false if it cannot be determined.
+func engineAPIVersionAtLeast(major, minor int) bool {
+	out, err := executil.Exec("docker", "version", "--format", "{{.Server.APIVersion}}")
+	if err != nil {
+		log.Printf("cannot get engine API version: %v: %s", err, strings.TrimSpace(string(out)))
+		return false
+	}
+	return apiVersionAtLeast(strings.TrimSpace(string(out)), major, minor)
+}
+
+func apiVersionAtLeast(v string, major, minor int) bool {
+	p := strings.SplitN(v, ".", 2)
+	if len(p) != 2 {
+		return false
+	}
+	ma, err1 := strconv.Atoi(p[0])
+	mi, err2 := strconv.Atoi(p[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return ma > major || (ma == major && mi >= minor)
+}
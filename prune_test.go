@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_validatePruneSettings(t *testing.T) {
+	for _, c := range []struct {
+		in pruneSettings
+		ok bool
+	}{
+		{pruneSettings{Schedule: "daily"}, true},
+		{pruneSettings{Schedule: "weekly", Filters: []string{"until=24h", "label=env=test", "label=tmp"}}, true},
+		{pruneSettings{Schedule: "yearly"}, false},
+		{pruneSettings{Schedule: "daily", Filters: []string{"until="}}, false},
+		{pruneSettings{Schedule: "daily", Filters: []string{"label"}}, false},
+		{pruneSettings{Schedule: "daily", Filters: []string{"dangling=false"}}, false},
+	} {
+		if err := validatePruneSettings(c.in); (err == nil) != c.ok {
+			t.Fatalf("got error=%v for %+v, expected ok=%v", err, c.in, c.ok)
+		}
+	}
+}
+
+func Test_pruneCommands(t *testing.T) {
+	c := pruneConfig{Filters: []string{"until=24h", "label=env=test"}}
+	expected := [][]string{
+		{"container", "prune", "--force", "--filter", "until=24h", "--filter", "label=env=test", "--filter", "label!=com.docker.compose.project"},
+		{"image", "prune", "--force", "--filter", "until=24h", "--filter", "label=env=test"},
+	}
+	if out := pruneCommands(c, true); !reflect.DeepEqual(out, expected) {
+		t.Fatalf("got %v, expected: %v", out, expected)
+	}
+
+	// until does not apply to volumes
+	c.Volumes = true
+	for _, all := range []bool{false, true} {
+		volume := []string{"volume", "prune", "--force", "--filter", "label=env=test"}
+		if all {
+			volume = append(volume, "--filter", "all=true")
+		}
+		volume = append(volume, "--filter", "label!=com.docker.compose.project")
+		out := pruneCommands(c, all)
+		if !reflect.DeepEqual(out, append(expected, volume)) {
+			t.Fatalf("got %v for all=%v, expected: %v", out, all, append(expected, volume))
+		}
+		if !reflect.DeepEqual(c.Filters, []string{"until=24h", "label=env=test"}) {
+			t.Fatalf("filters of the configuration are modified: %v", c.Filters)
+		}
+	}
+}
+
+func Test_apiVersionAtLeast(t *testing.T) {
+	for _, c := range []struct {
+		v   string
+		out bool
+	}{
+		{"1.42", true},
+		{"1.43", true},
+		{"2.0", true},
+		{"1.41", false},
+		{"1.4", false},
+		{"", false},
+		{"1.x", false},
+	} {
+		if out := apiVersionAtLeast(c.v, 1, 42); out != c.out {
+			t.Fatalf("got %v for %q, expected: %v", out, c.v, c.out)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	dockerRegistryCertsDir = "certs.d" // in dockerCfgDir
+	registryCACert         = "ca.crt"
+
+	// registryCAsState is the name of the state file that keeps the list of
+	// registry hosts whose CA certificates are installed by the extension.
+	registryCAsState = "registry-cas"
+)
+
+// registryConfig validates the registry settings and returns the daemon.json
+// configuration for them.
+func registryConfig(s dockerEngineSettings) (dockeropts.DaemonConfig, error) {
+	cfg := dockeropts.DaemonConfig{}
+	for _, m := range s.RegistryMirrors {
+		if err := dockeropts.ValidateRegistryMirror(m); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range s.InsecureRegistries {
+		if err := dockeropts.ValidateInsecureRegistry(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.RegistryMirrors) > 0 {
+		cfg["registry-mirrors"] = s.RegistryMirrors
+	}
+	if len(s.InsecureRegistries) > 0 {
+		cfg["insecure-registries"] = s.InsecureRegistries
+	}
+	return cfg, nil
+}
+
+// installRegistryCAs saves the CA certificates of the given registry hosts to
+// <dir>/<host>/ca.crt, where docker engine looks for them when connecting to
+// the registry. CA certificates installed previously for the hosts that are no
+// longer configured are removed.
+func installRegistryCAs(cas map[string]string, dir string) error {
+	certs := make(map[string][]byte)
+	hosts := make([]string, 0, len(cas))
+	for host, v := range cas {
+		if err := dockeropts.ValidateRegistryHost(host); err != nil {
+			return err
+		}
+		b := decodeSetting(v)
+		if err := validateCACert(b); err != nil {
+			return fmt.Errorf("invalid CA certificate for registry %s: %v", host, err)
+		}
+		certs[host] = b
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var prevHosts []string
+	if _, err := statefile.Get(registryCAsState, &prevHosts); err != nil {
+		return fmt.Errorf("error reading installed registry CAs: %v", err)
+	}
+	for _, host := range prevHosts {
+		if _, ok := certs[host]; ok {
+			continue
+		}
+		path := filepath.Join(dir, host, registryCACert)
+		log.Printf("Removing CA certificate of registry %s", host)
+		if err := backup.Save(path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error removing %s: %v", path, err)
+		}
+		os.Remove(filepath.Dir(path)) // only if empty
+	}
+
+	for _, host := range hosts {
+		path := filepath.Join(dir, host, registryCACert)
+		if existing, err := ioutil.ReadFile(path); err == nil && string(existing) == string(certs[host]) {
+			continue
+		}
+		log.Printf("Installing CA certificate of registry %s to %s", host, path)
+		if err := backup.Save(path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("error creating %s: %v", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, certs[host], 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", path, err)
+		}
+	}
+
+	if err := backup.Save(statefile.Path(registryCAsState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(registryCAsState, hosts); err != nil {
+		return fmt.Errorf("error saving installed registry CAs: %v", err)
+	}
+	return nil
+}
+
+// validateCACert returns error if b does not contain at least one PEM encoded
+// certificate, or any of the certificates cannot be parsed.
+func validateCACert(b []byte) error {
+	n := 0
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			break
+		}
+		if blk.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block %q", blk.Type)
+		}
+		if _, err := x509.ParseCertificate(blk.Bytes); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("no PEM encoded certificate found")
+	}
+	return nil
+}
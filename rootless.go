@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/driver"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+	"github.com/Azure/azure-docker-extension/pkg/subid"
+	"github.com/Azure/azure-docker-extension/pkg/vmextension"
+)
+
+const (
+	rootlessSetupTool = "dockerd-rootless-setuptool.sh" // from docker-ce-rootless-extras
+	rootlessProfile   = "/etc/profile.d/docker-extension-rootless.sh"
+
+	// rootlessState keeps the user the rootless engine is set up for.
+	rootlessState = "rootless"
+	// rootlessDaemonConfigState keeps the list of daemon.json keys of the
+	// rootless engine managed by the extension.
+	rootlessDaemonConfigState = "rootless-daemon-config"
+
+	rootlessRuntimeDirRetries = 15
+)
+
+// rootlessUser is the user running the rootless docker engine.
+type rootlessUser struct {
+	Name string `json:"name"`
+	home string
+	uid  int
+	gid  int
+}
+
+func lookupRootlessUser(name string) (rootlessUser, error) {
+	home, uid, gid, err := lookupUser(name)
+	if err != nil {
+		return rootlessUser{}, err
+	}
+	return rootlessUser{name, home, uid, gid}, nil
+}
+
+func (r rootlessUser) runtimeDir() string { return fmt.Sprintf("/run/user/%d", r.uid) }
+
+// dockerHost returns the address of the rootless engine of the user.
+func (r rootlessUser) dockerHost() string {
+	return "unix://" + filepath.Join(r.runtimeDir(), "docker.sock")
+}
+
+func (r rootlessUser) daemonConfigPath() string {
+	return filepath.Join(r.home, ".config", "docker", dockerDaemonConfig)
+}
+
+// exec runs the command as the user, in the environment of its systemd user
+// instance.
+func (r rootlessUser) exec(args ...string) ([]byte, error) {
+	return executil.Exec("runuser", append([]string{"-u", r.Name, "--", "env",
+		"HOME=" + r.home,
+		"XDG_RUNTIME_DIR=" + r.runtimeDir(),
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + filepath.Join(r.runtimeDir(), "bus"),
+	}, args...)...)
+}
+
+// enableRootless sets up the docker engine to run as the rootless user
+// instead of the system docker daemon, and creates the compose containers on
+// it.
+func enableRootless(he vmextension.HandlerEnvironment, d driver.DistroDriver, s DockerHandlerSettings, adminUser string, src driver.PackageSource, offline bool) error {
+	log.Printf("++ check rootless settings")
+	if err := checkRootlessSettings(s); err != nil {
+		return err
+	}
+	cfg, err := rootlessDaemonConfig(s.Docker)
+	if err != nil {
+		return fmt.Errorf("failed to build docker daemon configuration: %v", err)
+	}
+	name := s.Rootless.User
+	if name == "" {
+		name = adminUser
+	}
+	r, err := lookupRootlessUser(name)
+	if err != nil {
+		return fmt.Errorf("cannot set up rootless docker: %v", err)
+	}
+	effective, err := effectiveDaemonConfig(r.daemonConfigPath(), rootlessDaemonConfigState, cfg, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build docker daemon configuration: %v", err)
+	}
+	if err := checkPolicy(s, "", effective); err != nil {
+		return err
+	}
+	if s.Proxy.HTTP != "" || s.Proxy.HTTPS != "" {
+		addWarning("proxy is not configured for the rootless docker engine")
+	}
+	log.Printf("-- check rootless settings")
+
+	log.Printf("++ install rootless packages")
+	if offline {
+		log.Printf("using rootless packages from the offline installation bundle")
+	} else if err := withInstallRetries(func() error { return d.InstallRootlessPackages(src) }); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(rootlessSetupTool); err != nil {
+		return fmt.Errorf("%s is not installed, docker-ce-rootless-extras package is required", rootlessSetupTool)
+	}
+	log.Printf("-- install rootless packages")
+
+	log.Printf("++ disable system docker")
+	if err := teardownRootless(d, r.Name); err != nil {
+		return err
+	}
+	if err := d.SetDockerAutostart(false); err != nil {
+		return fmt.Errorf("failed to disable system docker: %v", err)
+	}
+	// the users added by the extension should no longer have root-equivalent
+	// access
+	if err := updateDockerGroupMembers(nil); err != nil {
+		return err
+	}
+	log.Printf("-- disable system docker")
+
+	log.Printf("++ setup rootless docker")
+	if err := setupRootless(r); err != nil {
+		return fmt.Errorf("failed to set up rootless docker for %s: %v", r.Name, err)
+	}
+	log.Printf("-- setup rootless docker")
+
+	log.Printf("++ update rootless daemon config")
+	cfgChanged, err := updateRootlessDaemonConfig(r, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to update docker daemon configuration: %v", err)
+	}
+	log.Printf("-- update rootless daemon config")
+
+	log.Printf("++ restart docker")
+	action := "start"
+	if cfgChanged {
+		action = "restart"
+	}
+	if out, err := r.exec("systemctl", "--user", action, "docker"); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("failed to %s rootless docker: %v", action, err)
+	}
+	// the rest of the commands (docker, docker-compose) use the rootless engine
+	os.Setenv("DOCKER_HOST", r.dockerHost())
+	if err := waitForDocker(); err != nil {
+		return err
+	}
+	log.Printf("-- restart docker")
+
+	log.Printf("++ setup client certs")
+	if err := installClientCerts(s, adminUser); err != nil {
+		return fmt.Errorf("error installing client certs: %v", err)
+	}
+	log.Printf("-- setup client certs")
+
+	log.Printf("++ login docker registry")
+	if err := loginRegistry(s.Login); err != nil {
+		return err
+	}
+	log.Printf("-- login docker registry")
+
+	log.Printf("++ update prune schedule")
+	if err := updatePruneSchedule(d, s.Prune); err != nil {
+		return err
+	}
+	log.Printf("-- update prune schedule")
+
+	log.Printf("++ prepare images")
+	if err := prepareImages(s.Images); err != nil {
+		return err
+	}
+	log.Printf("-- prepare images")
+
+	log.Printf("++ compose up")
+	if err := composeUp(d, s.ComposeJson, s.ComposeEnv, s.ComposeProtectedEnv); err != nil {
+		return fmt.Errorf("'docker-compose up' failed: %v. Check logs at %s.", err, filepath.Join(he.HandlerEnvironment.LogFolder, LogFilename))
+	}
+	log.Printf("-- compose up")
+	return nil
+}
+
+// checkRootlessSettings returns error if settings configuring the system
+// docker daemon are specified along with the rootless mode.
+func checkRootlessSettings(s DockerHandlerSettings) error {
+	return checkUnsupported("rootless mode", []namedSetting{
+		{"docker.port", s.Docker.Port != ""},
+		{"docker.listeners", len(s.Docker.Listeners) > 0},
+		{"docker.unix-socket", s.Docker.UnixSocket != unixSocketSettings{}},
+		{"docker.options", len(s.Docker.Options) > 0},
+		{"docker.generate-certs", s.Docker.GenerateCerts},
+		{"docker.data-root", s.Docker.DataRoot != ""},
+		{"docker.userns-remap", s.Docker.UsernsRemap != ""},
+		{"docker-users", len(s.DockerUsers) > 0},
+		{"docker-group-gid", s.DockerGroupGID != 0},
+		{"certs", s.Certs.HasDockerCerts()},
+		{"registry-cas", len(s.RegistryCAs) > 0},
+		{"swarm", s.Swarm.Mode != ""},
+		{"plugins", len(s.Plugins) > 0},
+	})
+}
+
+// rootlessDaemonConfig returns the daemon.json configuration of the rootless
+// engine from the docker settings.
+func rootlessDaemonConfig(s dockerEngineSettings) (dockeropts.DaemonConfig, error) {
+	cfg, err := engineConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.DaemonConfig {
+		if _, ok := cfg[k]; ok {
+			return nil, fmt.Errorf("daemon option %q is specified both in daemon-config and docker settings", k)
+		}
+		cfg[k] = v
+	}
+	return cfg, nil
+}
+
+// setupRootless allocates the subordinate IDs of the user, enables lingering
+// so that the user's engine runs without a login session, installs the
+// rootless engine as a systemd user unit and points DOCKER_HOST of the user
+// to it.
+func setupRootless(r rootlessUser) error {
+	uid, gid := strconv.Itoa(r.uid), strconv.Itoa(r.gid)
+	sr, changed, err := subid.Ensure(subid.SubUIDFile, subid.DefaultCount, r.Name, uid)
+	if err != nil {
+		return err
+	}
+	log.Printf("subuid range of %s: %d-%d (added: %v)", r.Name, sr.Start, sr.Start+sr.Count-1, changed)
+	sr, changed, err = subid.Ensure(subid.SubGIDFile, subid.DefaultCount, r.Name, gid)
+	if err != nil {
+		return err
+	}
+	log.Printf("subgid range of %s: %d-%d (added: %v)", r.Name, sr.Start, sr.Start+sr.Count-1, changed)
+
+	if out, err := executil.Exec("loginctl", "enable-linger", r.Name); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("error enabling lingering: %v", err)
+	}
+	// the user instance of systemd is started asynchronously
+	bus := filepath.Join(r.runtimeDir(), "bus")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(bus); err == nil {
+			break
+		} else if i == rootlessRuntimeDirRetries {
+			return fmt.Errorf("systemd user instance is not running: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	log.Printf("Installing rootless docker for %s", r.Name)
+	if out, err := r.exec(rootlessSetupTool, "install"); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("%s failed: %v", rootlessSetupTool, err)
+	}
+
+	profile := rootlessProfileScript(r)
+	if b, err := ioutil.ReadFile(rootlessProfile); err != nil || string(b) != profile {
+		if err := backup.Save(rootlessProfile); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(rootlessProfile, []byte(profile), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", rootlessProfile, err)
+		}
+	}
+
+	if err := backup.Save(statefile.Path(rootlessState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(rootlessState, r); err != nil {
+		return fmt.Errorf("error saving rootless docker user: %v", err)
+	}
+	return nil
+}
+
+// rootlessProfileScript returns the login script pointing DOCKER_HOST of the
+// user to its rootless engine.
+func rootlessProfileScript(r rootlessUser) string {
+	return fmt.Sprintf(`# rootless docker engine of %[1]s, configured by the docker extension
+if [ "$(id -un)" = "%[1]s" ]; then
+	export DOCKER_HOST=%[2]s
+fi
+`, r.Name, r.dockerHost())
+}
+
+// updateRootlessDaemonConfig updates the daemon.json of the rootless engine
+// in the configuration directory of the user.
+func updateRootlessDaemonConfig(r rootlessUser, cfg dockeropts.DaemonConfig) (bool, error) {
+	path := r.daemonConfigPath()
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, fmt.Errorf("error creating %s: %v", dir, err)
+	}
+	for _, p := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chown(p, r.uid, r.gid); err != nil {
+			return false, fmt.Errorf("error changing owner of %s: %v", p, err)
+		}
+	}
+	changed, err := updateDaemonConfig(path, rootlessDaemonConfigState, cfg, defaultDaemonConfig(), nil)
+	if err != nil || !changed {
+		return changed, err
+	}
+	if err := os.Chown(path, r.uid, r.gid); err != nil {
+		return false, fmt.Errorf("error changing owner of %s: %v", path, err)
+	}
+	return true, nil
+}
+
+// teardownRootless removes the rootless engine set up previously for a user
+// other than keepUser (or any user if empty), and enables the system docker
+// daemon again if keepUser is empty.
+func teardownRootless(d driver.DistroDriver, keepUser string) error {
+	var prev rootlessUser
+	if ok, err := statefile.Get(rootlessState, &prev); err != nil {
+		return fmt.Errorf("error reading rootless docker user: %v", err)
+	} else if !ok || prev.Name == keepUser {
+		return nil
+	}
+
+	log.Printf("Removing rootless docker of %s", prev.Name)
+	if r, err := lookupRootlessUser(prev.Name); err != nil {
+		log.Printf("WARNING: %v", err)
+	} else {
+		if out, err := r.exec(rootlessSetupTool, "uninstall", "-f"); err != nil {
+			log.Printf("%s", string(out))
+			addWarning("failed to uninstall rootless docker of %s: %v", r.Name, err)
+		}
+		if out, err := executil.Exec("loginctl", "disable-linger", r.Name); err != nil {
+			log.Printf("%s", string(out))
+			addWarning("failed to disable lingering of %s: %v", r.Name, err)
+		}
+	}
+
+	for _, path := range []string{rootlessProfile, statefile.Path(rootlessState)} {
+		if err := backup.Save(path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error removing %s: %v", path, err)
+		}
+	}
+	if keepUser != "" {
+		return nil
+	}
+	if err := d.SetDockerAutostart(true); err != nil {
+		return fmt.Errorf("failed to enable system docker: %v", err)
+	}
+	return nil
+}
+
+// stopRootless stops the rootless engine, if set up.
+func stopRootless() error {
+	var prev rootlessUser
+	if ok, err := statefile.Get(rootlessState, &prev); err != nil || !ok {
+		return err
+	}
+	r, err := lookupRootlessUser(prev.Name)
+	if err != nil {
+		return err
+	}
+	if out, err := r.exec("systemctl", "--user", "stop", "docker"); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("failed to stop rootless docker of %s: %v", r.Name, err)
+	}
+	return nil
+}
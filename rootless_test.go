@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+)
+
+func Test_rootlessUser(t *testing.T) {
+	r := rootlessUser{"azureuser", "/home/azureuser", 1000, 1000}
+	for _, c := range []struct{ got, expected string }{
+		{r.runtimeDir(), "/run/user/1000"},
+		{r.dockerHost(), "unix:///run/user/1000/docker.sock"},
+		{r.daemonConfigPath(), "/home/azureuser/.config/docker/daemon.json"},
+	} {
+		if c.got != c.expected {
+			t.Fatalf("got %q, expected: %q", c.got, c.expected)
+		}
+	}
+}
+
+func Test_rootlessProfileScript(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name, expected string
+	}{
+		{u.Username, "unix:///run/user/1000/docker.sock"}, // only set for the rootless user
+		{u.Username + "-other", ""},
+	} {
+		path := filepath.Join(dir, "profile.sh")
+		if err := ioutil.WriteFile(path, []byte(rootlessProfileScript(rootlessUser{c.name, "", 1000, 1000})), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command("sh", "-c", `unset DOCKER_HOST; . "$0"; printf %s "$DOCKER_HOST"`, path)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != c.expected {
+			t.Fatalf("got DOCKER_HOST=%q for user %s, expected: %q", out, c.name, c.expected)
+		}
+	}
+}
+
+func Test_rootlessDaemonConfig(t *testing.T) {
+	cfg, err := rootlessDaemonConfig(dockerEngineSettings{
+		RegistryMirrors: []string{"https://mirror.example.com"},
+		DaemonConfig:    map[string]interface{}{"debug": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := dockeropts.DaemonConfig{
+		"registry-mirrors": []string{"https://mirror.example.com"},
+		"debug":            true,
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("got %v, expected: %v", cfg, expected)
+	}
+
+	if _, err := rootlessDaemonConfig(dockerEngineSettings{
+		RegistryMirrors: []string{"https://mirror.example.com"},
+		DaemonConfig:    map[string]interface{}{"registry-mirrors": []string{}},
+	}); err == nil {
+		t.Fatal("expected error for key specified twice")
+	}
+}
+
+func Test_checkRootlessSettings(t *testing.T) {
+	var s DockerHandlerSettings
+	s.Docker.RegistryMirrors = []string{"https://mirror.example.com"}
+	if err := checkRootlessSettings(s); err != nil {
+		t.Fatal(err)
+	}
+	s.Docker.Port = "2376"
+	s.Docker.DataRoot = "/data/docker"
+	err := checkRootlessSettings(s)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, name := range []string{"docker.port", "docker.data-root"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("expected %s in error: %v", name, err)
+		}
+	}
+}
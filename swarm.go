@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+const (
+	swarmModeInit = "init"
+	swarmModeJoin = "join"
+
+	// swarmState keeps the swarm node created by the extension, so that only
+	// that node leaves the swarm when the settings are removed.
+	swarmState = "swarm"
+
+	swarmJoinRetries  = 6
+	swarmJoinInterval = 10 * time.Second
+)
+
+// swarmNode is the swarm membership of the engine.
+type swarmNode struct {
+	State   string `json:"-"` // LocalNodeState: inactive, pending, active, error or locked
+	ID      string `json:"id"`
+	Manager bool   `json:"manager"`
+}
+
+// currentSwarmNode returns the swarm membership of the engine.
+func currentSwarmNode() (swarmNode, error) {
+	out, err := executil.Exec("docker", "info", "--format", "{{.Swarm.LocalNodeState}}|{{.Swarm.NodeID}}|{{.Swarm.ControlAvailable}}")
+	if err != nil {
+		return swarmNode{}, fmt.Errorf("cannot get swarm state: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	p := strings.Split(strings.TrimSpace(string(out)), "|")
+	if len(p) != 3 {
+		return swarmNode{}, fmt.Errorf("cannot parse swarm state %q", string(out))
+	}
+	return swarmNode{p[0], p[1], p[2] == "true"}, nil
+}
+
+// updateSwarm initializes a swarm or joins the engine to a swarm as
+// configured, unless it is already part of a swarm. If swarm is no longer
+// configured, the node leaves the swarm if it was created by the extension.
+func updateSwarm(s DockerHandlerSettings) error {
+	if err := validateSwarmSettings(s); err != nil {
+		return err
+	}
+	node, err := currentSwarmNode()
+	if err != nil {
+		return err
+	}
+	var prev swarmNode
+	if _, err := statefile.Get(swarmState, &prev); err != nil {
+		return fmt.Errorf("error reading swarm state: %v", err)
+	}
+
+	if s.Swarm.Mode == "" {
+		if prev.ID == "" {
+			log.Printf("swarm not specified, noop")
+			return nil
+		}
+		if node.State != "inactive" && node.ID == prev.ID {
+			if err := leaveSwarm(node); err != nil {
+				return err
+			}
+		} else {
+			log.Printf("swarm node %s created by the extension no longer exists", prev.ID)
+		}
+		return saveSwarmNode(nil)
+	}
+
+	switch node.State {
+	case "active":
+		log.Printf("already part of a swarm (node %s, manager: %v)", node.ID, node.Manager)
+		if s.Swarm.Mode == swarmModeInit && !node.Manager {
+			addWarning("swarm init is specified but the node is already a worker of a swarm, not changed")
+		}
+		return nil
+	case "inactive":
+	default:
+		return fmt.Errorf("swarm node is in %q state, fix or leave the swarm manually", node.State)
+	}
+
+	addr := s.Swarm.AdvertiseAddress
+	if addr == "" {
+		if addr, err = primaryPrivateIP(); err != nil {
+			return fmt.Errorf("cannot get private IP address of the VM to advertise to the swarm: %v", err)
+		}
+	}
+	if s.Swarm.Mode == swarmModeInit {
+		log.Printf("Initializing swarm advertised at %s", addr)
+		if out, err := executil.Exec("docker", "swarm", "init", "--advertise-addr", addr); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("'docker swarm init' failed: %v", err)
+		}
+	} else if err := joinSwarm(s.SwarmJoinToken, s.Swarm.Managers, addr); err != nil {
+		return err
+	}
+
+	if node, err = currentSwarmNode(); err != nil {
+		return err
+	}
+	log.Printf("joined swarm as node %s (manager: %v)", node.ID, node.Manager)
+	return saveSwarmNode(&node)
+}
+
+func validateSwarmSettings(s DockerHandlerSettings) error {
+	switch s.Swarm.Mode {
+	case "":
+		return nil
+	case swarmModeInit:
+		if len(s.Swarm.Managers) > 0 {
+			return errors.New("swarm managers cannot be specified for swarm init")
+		}
+		return nil
+	case swarmModeJoin:
+		if len(s.Swarm.Managers) == 0 {
+			return errors.New("swarm managers are required to join a swarm")
+		}
+		if !strings.HasPrefix(s.SwarmJoinToken, "SWMTKN-") {
+			return errors.New("a valid swarm-join-token is required in protected settings to join a swarm")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid swarm mode %q, expected %q or %q", s.Swarm.Mode, swarmModeInit, swarmModeJoin)
+}
+
+// joinSwarm joins the swarm through one of the managers, retrying as the
+// managers may be still provisioning (e.g. in a scale set).
+func joinSwarm(token string, managers []string, addr string) error {
+	var err error
+	for i := 0; i < swarmJoinRetries; i++ {
+		if i > 0 {
+			log.Printf("sleeping %s", swarmJoinInterval)
+			time.Sleep(swarmJoinInterval)
+		}
+		for _, m := range managers {
+			log.Printf("Joining swarm through %s, advertised at %s", m, addr)
+			// the token is passed as an argument, which is not logged by Exec
+			// but is readable in /proc while the command runs, the CLI has
+			// no other way to pass it
+			var out []byte
+			if out, err = executil.Exec("docker", "swarm", "join", "--token", token, "--advertise-addr", addr, m); err == nil {
+				return nil
+			}
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+			log.Printf("joining swarm through %s failed: %v", m, err)
+		}
+	}
+	return fmt.Errorf("'docker swarm join' failed: %v", err)
+}
+
+// leaveSwarm removes the node from the swarm. A manager is forced to leave,
+// which may affect the quorum of the remaining managers.
+func leaveSwarm(node swarmNode) error {
+	args := []string{"swarm", "leave"}
+	if node.Manager {
+		args = append(args, "--force")
+		addWarning("swarm manager %s is forced to leave the swarm, remove it from the remaining managers with 'docker node rm'", node.ID)
+	}
+	log.Printf("Leaving swarm (node %s)", node.ID)
+	if out, err := executil.Exec("docker", args...); err != nil {
+		log.Printf("%s", string(out))
+		return fmt.Errorf("'docker swarm leave' failed: %v", err)
+	}
+	return nil
+}
+
+// saveSwarmNode records the swarm node created by the extension, or deletes
+// the record if node is nil.
+func saveSwarmNode(node *swarmNode) error {
+	if err := backup.Save(statefile.Path(swarmState)); err != nil {
+		return err
+	}
+	if node == nil {
+		return statefile.Delete(swarmState)
+	}
+	if err := statefile.Set(swarmState, node); err != nil {
+		return fmt.Errorf("error saving swarm state: %v", err)
+	}
+	return nil
+}
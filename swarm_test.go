@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func Test_validateSwarmSettings(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		mode     string
+		managers []string
+		token    string
+		ok       bool
+	}{
+		{"not specified", "", nil, "", true},
+		{"init", "init", nil, "", true},
+		{"init with managers", "init", []string{"10.0.0.4"}, "", false},
+		{"join", "join", []string{"10.0.0.4", "10.0.0.5:2377"}, "SWMTKN-1-abc", true},
+		{"join without managers", "join", nil, "SWMTKN-1-abc", false},
+		{"join without token", "join", []string{"10.0.0.4"}, "", false},
+		{"join with invalid token", "join", []string{"10.0.0.4"}, "abc", false},
+		{"invalid mode", "leave", nil, "", false},
+	} {
+		var s DockerHandlerSettings
+		s.Swarm.Mode, s.Swarm.Managers, s.SwarmJoinToken = c.mode, c.managers, c.token
+		if err := validateSwarmSettings(s); (err == nil) != c.ok {
+			t.Fatalf("%s: got error=%v, expected ok=%v", c.name, err, c.ok)
+		}
+	}
+}
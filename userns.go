@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-docker-extension/pkg/backup"
+	"github.com/Azure/azure-docker-extension/pkg/dockeropts"
+	"github.com/Azure/azure-docker-extension/pkg/executil"
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+	"github.com/Azure/azure-docker-extension/pkg/subid"
+)
+
+const (
+	// defaultRemapUser is the user dockerd uses for "userns-remap": "default".
+	defaultRemapUser = "dockremap"
+
+	// usernsRemapState keeps the effective userns-remap of the last enable,
+	// which may be set in daemon.json or in the daemon options.
+	usernsRemapState = "userns-remap"
+)
+
+var userNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+// parseUsernsRemap returns the user and group of the given userns-remap
+// setting ("default" or "user[:group]").
+func parseUsernsRemap(v string) (userName, groupName string, err error) {
+	if v == "default" {
+		return defaultRemapUser, defaultRemapUser, nil
+	}
+	p := strings.SplitN(v, ":", 2)
+	userName, groupName = p[0], p[0]
+	if len(p) == 2 {
+		groupName = p[1]
+	}
+	if !userNameRegexp.MatchString(userName) || !userNameRegexp.MatchString(groupName) {
+		return "", "", fmt.Errorf("invalid userns-remap %q, expected \"default\" or user[:group]", v)
+	}
+	return userName, groupName, nil
+}
+
+// setupUsernsRemap creates the remap user and group (if they do not exist)
+// and their subordinate ID ranges required by dockerd for the userns-remap
+// setting. Switching userns-remap is reported as a warning as the existing
+// images, containers and volumes are not visible to the engine afterwards.
+func setupUsernsRemap(remap, daemonConfigPath string) error {
+	prev := currentUsernsRemap(daemonConfigPath)
+	if _, err := statefile.Get(usernsRemapState, &prev); err != nil {
+		return fmt.Errorf("error reading userns-remap state: %v", err)
+	}
+	if prev != remap {
+		addWarning("userns-remap is changed from %q to %q, the existing images, containers and volumes are not visible to docker afterwards (they are kept in a different directory of the data-root)", prev, remap)
+	}
+	if err := backup.Save(statefile.Path(usernsRemapState)); err != nil {
+		return err
+	}
+	if err := statefile.Set(usernsRemapState, remap); err != nil {
+		return fmt.Errorf("error saving userns-remap state: %v", err)
+	}
+	if remap == "" {
+		return nil
+	}
+	userName, groupName, err := parseUsernsRemap(remap)
+	if err != nil {
+		return err
+	}
+
+	if _, err := user.LookupGroup(groupName); err != nil {
+		log.Printf("Creating group %s for userns-remap", groupName)
+		if out, err := executil.Exec("groupadd", "--system", groupName); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("error creating group %s: %v", groupName, err)
+		}
+	}
+	if _, err := user.Lookup(userName); err != nil {
+		log.Printf("Creating user %s for userns-remap", userName)
+		if out, err := executil.Exec("useradd", "--system", "--no-create-home",
+			"--shell", "/usr/sbin/nologin", "--gid", groupName, userName); err != nil {
+			log.Printf("%s", string(out))
+			return fmt.Errorf("error creating user %s: %v", userName, err)
+		}
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return fmt.Errorf("cannot find user %s: %v", userName, err)
+	}
+	g, err := user.LookupGroup(groupName)
+	if err != nil {
+		return fmt.Errorf("cannot find group %s: %v", groupName, err)
+	}
+
+	r, changed, err := subid.Ensure(subid.SubUIDFile, subid.DefaultCount, userName, u.Uid)
+	if err != nil {
+		return err
+	}
+	log.Printf("subuid range of %s: %d-%d (added: %v)", userName, r.Start, r.Start+r.Count-1, changed)
+	r, changed, err = subid.Ensure(subid.SubGIDFile, subid.DefaultCount, groupName, g.Gid)
+	if err != nil {
+		return err
+	}
+	log.Printf("subgid range of %s: %d-%d (added: %v)", groupName, r.Start, r.Start+r.Count-1, changed)
+	return nil
+}
+
+// currentUsernsRemap returns the userns-remap setting in daemon.json.
+func currentUsernsRemap(daemonConfigPath string) string {
+	b, err := ioutil.ReadFile(daemonConfigPath)
+	if err != nil {
+		return ""
+	}
+	cfg, err := dockeropts.ParseDaemonConfig(b)
+	if err != nil {
+		return ""
+	}
+	v, _ := cfg["userns-remap"].(string)
+	return v
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-docker-extension/pkg/statefile"
+)
+
+func Test_setupUsernsRemap_changeWarning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { statefile.Dir = d }(statefile.Dir)
+	statefile.Dir = dir
+	defer func(w []string) { warnings = w }(warnings)
+
+	// without state, the previous value is read from daemon.json
+	path := filepath.Join(dir, "daemon.json")
+	if err := ioutil.WriteFile(path, []byte(`{"userns-remap": "default"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	warnings = nil
+	if err := setupUsernsRemap("", path); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected a warning for the change, got: %v", warnings)
+	}
+
+	// the recorded value is used afterwards, e.g. if the daemon.json is not
+	// updated yet or the value is in the daemon options
+	warnings = nil
+	if err := setupUsernsRemap("", path); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got: %v", warnings)
+	}
+}